@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/veandco/go-sdl2/sdl"
+	"math"
+)
+
+const audioSampleRate = 44100
+
+// how much audio we try to keep queued in the device, in samples
+const audioTargetLatency = audioSampleRate / 20 // 50 ms
+
+// maximum deviation from the nominal sample rate used to keep the queue at the target
+// https://github.com/libretro/docs/blob/master/archive/ratecontrol.pdf
+const audioMaxRateDelta = 0.005
+
+// ring buffer of samples produced by the APU, waiting to be queued to the device
+type audioRing struct {
+	samples    [audioSampleRate]int16
+	readIndex  int
+	writeIndex int
+	count      int
+}
+
+func (r *audioRing) push(sample int16) {
+	if r.count == len(r.samples) {
+		// overrun: drop the oldest sample
+		r.readIndex = (r.readIndex + 1) % len(r.samples)
+		r.count--
+	}
+	r.samples[r.writeIndex] = sample
+	r.writeIndex = (r.writeIndex + 1) % len(r.samples)
+	r.count++
+}
+
+// drain into `out` as little-endian 16-bit PCM, returning the filled slice
+func (r *audioRing) drain(out []byte) []byte {
+	out = out[:0]
+	for r.count > 0 {
+		var b [2]byte
+		binary.LittleEndian.PutUint16(b[:], uint16(r.samples[r.readIndex]))
+		out = append(out, b[0], b[1])
+		r.readIndex = (r.readIndex + 1) % len(r.samples)
+		r.count--
+	}
+	return out
+}
+
+var audioDevice sdl.AudioDeviceID
+var audioEnabled bool
+var audioPending audioRing
+var audioQueueBuffer []byte
+
+func audioInit() {
+	desired := sdl.AudioSpec{
+		Freq:     audioSampleRate,
+		Format:   sdl.AUDIO_S16LSB,
+		Channels: 1,
+		Samples:  1024,
+	}
+	var obtained sdl.AudioSpec
+	var err error
+	audioDevice, err = sdl.OpenAudioDevice("", false, &desired, &obtained, 0)
+	if err != nil {
+		fmt.Println("could not open audio device:", err)
+		return
+	}
+	audioEnabled = true
+	audioQueueBuffer = make([]byte, 0, 2*len(audioPending.samples))
+
+	console.SetAudioSampleRate(float64(obtained.Freq))
+	console.SetAudio(frontend{})
+	sdl.PauseAudioDevice(audioDevice, false)
+}
+
+func (frontend) PushSample(sample float32) {
+	s := math.Max(-1, math.Min(1, float64(sample)))
+	audioPending.push(int16(s * math.MaxInt16))
+}
+
+// audioQueued returns the number of samples waiting in the device queue
+func audioQueued() int {
+	return int(sdl.GetQueuedAudioSize(audioDevice)) / 2
+}
+
+// hand everything the APU produced this frame to SDL, and nudge the APU
+// sample rate so that the device queue hovers around the target latency
+func audioFlush() {
+	audioQueueBuffer = audioPending.drain(audioQueueBuffer)
+	if len(audioQueueBuffer) > 0 {
+		sdl.QueueAudio(audioDevice, audioQueueBuffer)
+	}
+
+	fill := float64(audioQueued()) / audioTargetLatency
+	ratio := 1 + audioMaxRateDelta*(1-fill)
+	ratio = math.Max(1-audioMaxRateDelta, math.Min(1+audioMaxRateDelta, ratio))
+	console.SetAudioRateAdjustment(ratio)
+}
+
+// block until the device queue has drained down to the target latency.
+// this is what paces the emulation when audio is available.
+func audioWait() {
+	for audioQueued() > audioTargetLatency {
+		sdl.Delay(1)
+	}
+}
+
+// drop anything queued (e.g. when pausing) so we don't resume with stale audio
+func audioClear() {
+	audioPending.drain(audioQueueBuffer)
+	sdl.ClearQueuedAudio(audioDevice)
+}
+
+func audioCleanup() {
+	if audioEnabled {
+		sdl.CloseAudioDevice(audioDevice)
+	}
+}
@@ -0,0 +1,679 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/elipsitz/nes-go/headless"
+	"github.com/elipsitz/nes-go/nes"
+	"github.com/veandco/go-sdl2/sdl"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func check(e error) {
+	if e != nil {
+		fatal(e)
+	}
+}
+
+func fatal(e error) {
+	closeTrace()
+	fmt.Fprintln(os.Stderr, "aenes:", e)
+	os.Exit(1)
+}
+
+func usageError(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "aenes: "+format+"\n", args...)
+	flag.Usage()
+	os.Exit(2)
+}
+
+var window *sdl.Window
+var windowRenderer *sdl.Renderer
+var windowTexture *sdl.Texture
+var buffer [w * h * 4]byte
+var ntscFilter *nes.NtscFilter // optional
+var ntscBuffer [nes.NtscWidth * h * 4]byte
+var debugSurface *sdl.Surface
+var debugRenderer *sdl.Renderer
+var debugTexture *sdl.Texture
+
+var console *nes.Nes
+var buttons [2][8]bool
+var romPath string
+var saveDir string
+var stateSlot = 1
+var debug int
+var framesRendered int
+var fpsTimer time.Time
+
+const debugNumScreens = 2
+const batteryFlushFrames = 5 * 60 // how often to write out dirty battery RAM
+const w = 256
+const h = 240
+
+var scale = 2
+var paused bool
+var maxFrames int // quit after this many frames, if nonzero
+var traceDivergenceShown bool
+var traceFile *os.File
+var traceOutput *bufio.Writer
+
+// palettes that can be switched between at runtime
+type namedPalette struct {
+	name    string
+	palette nes.Palette
+}
+
+var palettes []namedPalette
+var paletteIndex int
+var haltShown bool
+
+func sdlInit() {
+	var err error
+	sdl.Init(sdl.INIT_EVERYTHING)
+
+	if ntscFilter != nil {
+		// the filtered picture is stretched to the same height
+		window, windowRenderer, err = sdl.CreateWindowAndRenderer(int32(nes.NtscWidth*scale/2), int32(h*scale), 0)
+		check(err)
+		windowTexture, err = windowRenderer.CreateTexture(sdl.PIXELFORMAT_ARGB8888, sdl.TEXTUREACCESS_STREAMING, nes.NtscWidth, h)
+	} else {
+		window, windowRenderer, err = sdl.CreateWindowAndRenderer(int32(w*scale), int32(h*scale), 0)
+		check(err)
+		windowTexture, err = windowRenderer.CreateTexture(sdl.PIXELFORMAT_ARGB8888, sdl.TEXTUREACCESS_STREAMING, w, h)
+	}
+	check(err)
+
+	debugSurface, err = sdl.CreateRGBSurface(0, int32(w*scale), int32(h*scale), 32, 0x00ff0000, 0x0000ff00, 0x000000ff, 0xff000000)
+	check(err)
+	debugRenderer, err = sdl.CreateSoftwareRenderer(debugSurface)
+	check(err)
+	debugTexture, err = windowRenderer.CreateTexture(sdl.PIXELFORMAT_ARGB8888, sdl.TEXTUREACCESS_STREAMING, int32(w*scale), int32(h*scale))
+	check(err)
+	debugTexture.SetBlendMode(sdl.BLENDMODE_BLEND)
+
+	debugRenderer.SetScale(float32(scale), float32(scale))
+	fpsTimer = time.Now()
+}
+
+func sdlLoop() {
+	frameDuration := time.Duration(float64(time.Second) / console.FrameRate())
+	var event sdl.Event
+	running := true
+	for running {
+		frameStart := time.Now()
+
+		for event = sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+			switch t := event.(type) {
+			case *sdl.QuitEvent:
+				running = false
+			case *sdl.KeyboardEvent:
+				pressed := t.Type == sdl.KEYDOWN
+				switch t.Keysym.Scancode {
+				case sdl.SCANCODE_RETURN:
+					buttons[0][nes.ButtonStart] = pressed
+				case sdl.SCANCODE_RSHIFT:
+					buttons[0][nes.ButtonSelect] = pressed
+				case sdl.SCANCODE_LEFT:
+					buttons[0][nes.ButtonLeft] = pressed
+				case sdl.SCANCODE_RIGHT:
+					buttons[0][nes.ButtonRight] = pressed
+				case sdl.SCANCODE_UP:
+					buttons[0][nes.ButtonUp] = pressed
+				case sdl.SCANCODE_DOWN:
+					buttons[0][nes.ButtonDown] = pressed
+				case sdl.SCANCODE_Z:
+					buttons[0][nes.ButtonA] = pressed
+				case sdl.SCANCODE_X:
+					buttons[0][nes.ButtonB] = pressed
+				case sdl.SCANCODE_1, sdl.SCANCODE_2, sdl.SCANCODE_3, sdl.SCANCODE_4, sdl.SCANCODE_5,
+					sdl.SCANCODE_6, sdl.SCANCODE_7, sdl.SCANCODE_8, sdl.SCANCODE_9:
+					if !pressed {
+						stateSlot = int(t.Keysym.Scancode-sdl.SCANCODE_1) + 1
+						fmt.Println("Save state slot", stateSlot)
+					}
+				case sdl.SCANCODE_F2:
+					if !pressed {
+						console.Reset()
+						haltShown = false
+						fmt.Println("Reset.")
+					}
+				case sdl.SCANCODE_F3:
+					if !pressed {
+						check(console.PowerCycle())
+						haltShown = false
+						if audioEnabled {
+							audioClear()
+						}
+						fmt.Println("Power cycled.")
+					}
+				case sdl.SCANCODE_F4:
+					if !pressed {
+						paletteIndex = (paletteIndex + 1) % len(palettes)
+						console.SetPalette(palettes[paletteIndex].palette)
+						fmt.Println("Palette:", palettes[paletteIndex].name)
+					}
+				case sdl.SCANCODE_F5:
+					if !pressed {
+						quickSave()
+					}
+				case sdl.SCANCODE_F7:
+					if !pressed {
+						quickLoad()
+					}
+				case sdl.SCANCODE_F8:
+					if !pressed {
+						console.SetUnlimitedSprites(!console.UnlimitedSprites())
+						if console.UnlimitedSprites() {
+							fmt.Println("Sprite limit off.")
+						} else {
+							fmt.Println("Sprite limit on.")
+						}
+					}
+				case sdl.SCANCODE_GRAVE:
+					if !pressed {
+						debug = (debug + 1) % (debugNumScreens + 1)
+					}
+				case sdl.SCANCODE_SPACE:
+					if !pressed {
+						paused = !paused
+						if paused {
+							fmt.Println("Paused.")
+						} else {
+							fmt.Println("Unpaused.")
+						}
+						if audioEnabled {
+							audioClear()
+						}
+					}
+				}
+			}
+		}
+
+		if !paused {
+			console.EmulateFrame()
+			if maxFrames > 0 && console.Frame() >= maxFrames {
+				running = false
+			}
+			if err := console.Halted(); err != nil && !haltShown {
+				fmt.Println(err)
+				haltShown = true
+			}
+			if err := console.TraceDivergence(); err != nil && !traceDivergenceShown {
+				// stop where it happened, for a look at the screen
+				fmt.Println(err)
+				traceDivergenceShown = true
+				paused = true
+				if audioEnabled {
+					audioClear()
+				}
+			}
+		}
+
+		if audioEnabled && !paused {
+			// the audio queue paces us
+			audioFlush()
+			audioWait()
+		} else {
+			frameTime := time.Now().Sub(frameStart)
+			delay := (frameDuration - frameTime) / time.Millisecond
+			if delay > 0 {
+				sdl.Delay(uint32(delay))
+			}
+		}
+
+		framesRendered += 1
+		if console.Frame()%batteryFlushFrames == 0 && console.HasBattery() && console.BatteryDirty() {
+			saveBattery()
+		}
+
+		timeSpent := time.Now().Sub(fpsTimer)
+		if timeSpent.Seconds() > 1 {
+			fps := float64(framesRendered) / timeSpent.Seconds()
+			fpsTimer = time.Now()
+			framesRendered = 0
+			title := fmt.Sprintf("aeNes - FPS: %d", int(fps))
+			if console.Halted() != nil {
+				title += " - CPU halted"
+			}
+			window.SetTitle(title)
+		}
+	}
+}
+
+// savePath returns where a file belonging to the ROM is kept: next to it, unless -savedir is given.
+func savePath(name string) string {
+	if saveDir == "" {
+		return filepath.Join(filepath.Dir(romPath), name)
+	}
+	return filepath.Join(saveDir, name)
+}
+
+func batteryPath() string {
+	base := filepath.Base(romPath)
+	return savePath(strings.TrimSuffix(base, filepath.Ext(base)) + ".sav")
+}
+
+func loadBattery() {
+	f, err := os.Open(batteryPath())
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = console.LoadBattery(f)
+		f.Close()
+	}
+	if err != nil {
+		fmt.Println("could not load battery save:", err)
+		return
+	}
+	fmt.Println("loaded", batteryPath())
+}
+
+func saveBattery() {
+	// write to a temporary file first so a crash can't leave a truncated save
+	path := batteryPath()
+	f, err := os.Create(path + ".tmp")
+	if err == nil {
+		err = console.SaveBattery(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		fmt.Println("could not write battery save:", err)
+	}
+}
+
+func statePath() string {
+	return savePath(fmt.Sprintf("%s.state%d", filepath.Base(romPath), stateSlot))
+}
+
+func quickSave() {
+	f, err := os.Create(statePath())
+	if err == nil {
+		err = console.SaveState(f)
+		f.Close()
+	}
+	if err != nil {
+		fmt.Println("could not save state:", err)
+		return
+	}
+	fmt.Println("Saved state to slot", stateSlot)
+}
+
+func quickLoad() {
+	f, err := os.Open(statePath())
+	if err == nil {
+		err = console.LoadState(f)
+		f.Close()
+	}
+	if err != nil {
+		fmt.Println("could not load state:", err)
+		return
+	}
+	if audioEnabled {
+		audioClear()
+	}
+	fmt.Println("Loaded state from slot", stateSlot)
+}
+
+// frontend is the SDL implementation of the emulator's video, audio and input
+type frontend struct{}
+
+func (frontend) Buttons(port int) [8]bool {
+	return buttons[port]
+}
+
+func (frontend) PushPixel(x int, y int, col nes.Color) {
+	buffer[(y*w+x)*4+0] = byte((uint32(col) >> 0) & 0xFF)
+	buffer[(y*w+x)*4+1] = byte((uint32(col) >> 8) & 0xFF)
+	buffer[(y*w+x)*4+2] = byte((uint32(col) >> 16) & 0xFF)
+	buffer[(y*w+x)*4+3] = byte((uint32(col) >> 24) & 0xFF)
+}
+
+func (frontend) PushRawPixel(x int, y int, pixel uint16, phase int) {
+	if ntscFilter != nil {
+		ntscFilter.PushRawPixel(x, y, pixel, phase)
+	}
+}
+
+func drawDebug() {
+	if debug > 0 {
+		if debug == 1 {
+			debugRenderer.SetDrawColor(0, 255, 0, 255)
+			oam := console.OAM()
+			for i := 0; i < 256; i += 4 {
+				x, y := oam[i+3], oam[i+0]
+				debugRenderer.DrawRect(&sdl.Rect{X: int32(x), Y: int32(y), W: 8, H: 8})
+			}
+		}
+
+		if debug == 2 {
+			// draw pattern tables
+			for x := 0; x < 256; x++ {
+				for y := 0; y < 128; y++ {
+					addr := 0
+					addr |= y % 8
+					addr |= (x % 128 / 8) << 4
+					addr |= (y % 128 / 8) << 8
+					if x >= 128 {
+						addr |= 0x1000
+					}
+					lo, hi := console.PeekPPU(uint16(addr)), console.PeekPPU(uint16(addr+8))
+					col := (((lo << uint(x%8)) & 0x80) >> 7) | (((hi << uint(x%8)) & 0x80) >> 6)
+					col += 1
+					debugRenderer.SetDrawColor(col*60, col*60, col*60, 255)
+					debugRenderer.DrawPoint(int32(x), int32(y))
+				}
+			}
+		}
+
+		pixels := debugSurface.Pixels()
+		debugTexture.Update(nil, pixels, 4*w*scale)
+		windowRenderer.Copy(debugTexture, nil, nil)
+		debugSurface.FillRect(nil, 0x00000000)
+	}
+}
+
+func (frontend) PushFrame() {
+	// https://wiki.libsdl.org/MigrationGuide#If_your_game_just_wants_to_get_fully-rendered_frames_to_the_screen
+	if ntscFilter != nil {
+		for i, col := range ntscFilter.Render() {
+			ntscBuffer[i*4+0] = byte(col)
+			ntscBuffer[i*4+1] = byte(col >> 8)
+			ntscBuffer[i*4+2] = byte(col >> 16)
+		}
+		windowTexture.Update(nil, ntscBuffer[:], 4*nes.NtscWidth)
+	} else {
+		windowTexture.Update(nil, buffer[:], 4*w)
+	}
+	windowRenderer.Copy(windowTexture, nil, nil)
+	drawDebug()
+	windowRenderer.Present()
+}
+
+func sdlCleanup() {
+	audioCleanup()
+	window.Destroy()
+	sdl.Quit()
+}
+
+func writeFile(path string, write func(f *os.File) error) {
+	f, err := os.Create(path)
+	if err == nil {
+		err = write(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// closeTrace flushes and closes the -trace file, if it's open. It's called on the
+// way out, by fatal too, since os.Exit skips deferred calls.
+func closeTrace() error {
+	if traceOutput == nil {
+		return nil
+	}
+	err := traceOutput.Flush()
+	if closeErr := traceFile.Close(); err == nil {
+		err = closeErr
+	}
+	traceOutput, traceFile = nil, nil
+	return err
+}
+
+// runHeadless emulates without SDL and prints hashes of the video and audio output.
+func runHeadless(frames int, script []headless.InputStep, pngPath string, ramPath string) {
+	runner := headless.NewRunner(console)
+	runner.SetNtscFilter(ntscFilter)
+	runner.SetScript(script)
+
+	runner.Run(frames)
+
+	if pngPath != "" {
+		writeFile(pngPath, func(f *os.File) error { return runner.WritePNG(f) })
+	}
+	if ramPath != "" {
+		writeFile(ramPath, func(f *os.File) error {
+			ram := console.RAM()
+			_, err := f.Write(ram[:])
+			return err
+		})
+	}
+	if err := console.Halted(); err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println("video", runner.VideoHash())
+	fmt.Println("audio", runner.AudioHash())
+}
+
+func parseRegion(name string) (nes.Region, bool) {
+	switch strings.ToLower(name) {
+	case "ntsc":
+		return nes.RegionNTSC, true
+	case "pal":
+		return nes.RegionPAL, true
+	case "dendy":
+		return nes.RegionDendy, true
+	}
+	return 0, false
+}
+
+func parseFill(name string) (nes.FillPattern, bool) {
+	for _, fill := range []nes.FillPattern{nes.FillZeros, nes.FillOnes, nes.FillAlternating, nes.FillRandom} {
+		if strings.ToLower(name) == fill.String() {
+			return fill, true
+		}
+	}
+	return 0, false
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: aenes [flags] rom.nes\n")
+		flag.PrintDefaults()
+	}
+	flagScale := flag.Int("scale", 2, "window scale `factor`")
+	flagPaused := flag.Bool("paused", false, "start paused")
+	flagRegion := flag.String("region", "auto", "console `region`: auto, ntsc, pal or dendy")
+	flagPalette := flag.String("palette", "", "load colors from a 192 or 1536-byte .pal `file`, or \"ntsc\" to generate them (F4 switches palettes)")
+	flagNtsc := flag.Bool("ntsc", false, "filter the picture through a model of the NTSC composite signal (uses the NTSC palette settings)")
+	flagHue := flag.Float64("hue", nes.DefaultNtscParams.Hue, "generated NTSC palette: hue shift in `degrees`")
+	flagSaturation := flag.Float64("saturation", nes.DefaultNtscParams.Saturation, "generated NTSC palette: `saturation`")
+	flagContrast := flag.Float64("contrast", nes.DefaultNtscParams.Contrast, "generated NTSC palette: `contrast`")
+	flagBrightness := flag.Float64("brightness", nes.DefaultNtscParams.Brightness, "generated NTSC palette: `brightness`")
+	flagGamma := flag.Float64("gamma", nes.DefaultNtscParams.Gamma, "generated NTSC palette: display `gamma`")
+	flagFill := flag.String("fill", "zeros", "power-on RAM `pattern`: zeros, ones, alternating or random")
+	flagAlign := flag.Bool("align", false, "randomize the CPU/PPU clock alignment at power on")
+	flagSeed := flag.Int64("seed", 0, "random `seed` for -fill random and -align (default: from the clock)")
+	flagNoSpriteLimit := flag.Bool("nospritelimit", false, "draw every sprite on a scanline, not just 8, to reduce flicker (F8 toggles)")
+	flagSaveDir := flag.String("savedir", "", "`directory` for battery saves and save states (default: next to the ROM)")
+	flagTrace := flag.String("trace", "", "write a CPU instruction trace to `file`")
+	flagCompare := flag.String("compare", "", "compare execution against a nestest-format trace `file`")
+	flagHeadless := flag.Bool("headless", false, "run without a window or audio (requires -frames)")
+	flagFrames := flag.Int("frames", 0, "quit after emulating `n` frames (0: run until closed)")
+	flagInput := flag.String("input", "", "headless: read controller input from a script `file`")
+	flagPng := flag.String("png", "", "headless: write the final frame to `file` as PNG")
+	flagRam := flag.String("ram", "", "headless: write the 2 KB of work RAM to `file`")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usageError("expected exactly one ROM file")
+	}
+	romPath = flag.Arg(0)
+	if *flagScale < 1 || *flagScale > 16 {
+		usageError("invalid -scale %d: must be between 1 and 16", *flagScale)
+	}
+	scale = *flagScale
+	if *flagFrames < 0 {
+		usageError("invalid -frames %d: must not be negative", *flagFrames)
+	}
+	if *flagHeadless && *flagFrames == 0 {
+		usageError("-headless requires -frames")
+	}
+	if !*flagHeadless && (*flagInput != "" || *flagPng != "" || *flagRam != "") {
+		usageError("-input, -png and -ram require -headless")
+	}
+	region, regionOk := parseRegion(*flagRegion)
+	if !regionOk && *flagRegion != "auto" {
+		usageError("invalid -region %q: must be auto, ntsc, pal or dendy", *flagRegion)
+	}
+	fill, fillOk := parseFill(*flagFill)
+	if !fillOk {
+		usageError("invalid -fill %q: must be zeros, ones, alternating or random", *flagFill)
+	}
+	seedSet := false
+	flag.Visit(func(f *flag.Flag) {
+		seedSet = seedSet || f.Name == "seed"
+	})
+	if !seedSet {
+		*flagSeed = time.Now().UnixNano()
+	}
+	if *flagSaveDir != "" {
+		if info, err := os.Stat(*flagSaveDir); err != nil || !info.IsDir() {
+			usageError("invalid -savedir %q: not a directory", *flagSaveDir)
+		}
+		saveDir = *flagSaveDir
+	}
+	if *flagSaturation < 0 {
+		usageError("invalid -saturation %g: must not be negative", *flagSaturation)
+	}
+	if *flagContrast <= 0 {
+		usageError("invalid -contrast %g: must be positive", *flagContrast)
+	}
+	if *flagGamma <= 0 {
+		usageError("invalid -gamma %g: must be positive", *flagGamma)
+	}
+
+	palettes = []namedPalette{{"default", nes.DefaultPalette()}}
+	if *flagPalette != "" && *flagPalette != "ntsc" {
+		f, err := os.Open(*flagPalette)
+		if err != nil {
+			fatal(err)
+		}
+		palette, err := nes.LoadPalette(f)
+		f.Close()
+		if err != nil {
+			fatal(fmt.Errorf("%s: %v", *flagPalette, err))
+		}
+		palettes = append(palettes, namedPalette{filepath.Base(*flagPalette), palette})
+		paletteIndex = len(palettes) - 1
+	}
+	ntscParams := nes.NtscParams{
+		Hue:        *flagHue,
+		Saturation: *flagSaturation,
+		Contrast:   *flagContrast,
+		Brightness: *flagBrightness,
+		Gamma:      *flagGamma,
+	}
+	palettes = append(palettes, namedPalette{"NTSC", nes.GenerateNtscPalette(ntscParams)})
+	if *flagNtsc {
+		ntscFilter = nes.NewNtscFilter(ntscParams)
+	}
+	if *flagPalette == "ntsc" {
+		paletteIndex = len(palettes) - 1
+	}
+
+	var script []headless.InputStep
+	if *flagInput != "" {
+		f, err := os.Open(*flagInput)
+		if err != nil {
+			fatal(err)
+		}
+		script, err = headless.ParseScript(f)
+		f.Close()
+		if err != nil {
+			fatal(fmt.Errorf("%s: %v", *flagInput, err))
+		}
+	}
+
+	fmt.Println("aeNES")
+	fmt.Println("loading", romPath)
+
+	cartridge, err := nes.LoadCartridgeFile(romPath)
+	if err == nil {
+		console, err = nes.NewNes(cartridge)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not load %s: %v\n", romPath, err)
+		os.Exit(1)
+	}
+	a, b, c := cartridge.CRC32()
+	fmt.Printf("CRC32: %.8X, %.8X, %.8X\n", a, b, c)
+	fmt.Printf("Mapper ID: %d\n", cartridge.MapperID())
+	if cartridge.IsNes2() {
+		fmt.Printf("NES 2.0 header, submapper %d, timing %d\n", cartridge.Submapper(), cartridge.Timing())
+	}
+	if size := cartridge.PrgRamSize() + cartridge.PrgNvramSize(); size > 0 {
+		fmt.Printf("PRG RAM: %d bytes (%d battery backed)\n", size, cartridge.PrgNvramSize())
+	}
+	if regionOk {
+		console.SetRegion(region)
+	}
+	fmt.Println("Region:", console.Region())
+	if fill != nes.FillZeros || *flagAlign {
+		check(console.SetPowerOnState(nes.PowerOnState{Fill: fill, RandomAlignment: *flagAlign, Seed: *flagSeed}))
+		if fill == nes.FillRandom || *flagAlign {
+			fmt.Println("Power-on seed:", *flagSeed)
+		}
+	}
+
+	console.SetUnlimitedSprites(*flagNoSpriteLimit)
+	console.SetPalette(palettes[paletteIndex].palette)
+
+	if *flagTrace != "" {
+		f, err := os.Create(*flagTrace)
+		if err != nil {
+			fatal(err)
+		}
+		traceFile, traceOutput = f, bufio.NewWriter(f)
+		console.SetTraceOutput(traceOutput)
+	}
+
+	if *flagCompare != "" {
+		f, err := os.Open(*flagCompare)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		console.SetReferenceLog(f)
+	}
+
+	if console.HasBattery() {
+		loadBattery()
+	}
+
+	if *flagHeadless {
+		runHeadless(*flagFrames, script, *flagPng, *flagRam)
+	} else {
+		console.SetVideo(frontend{})
+		console.SetInput(frontend{})
+		paused = *flagPaused
+		maxFrames = *flagFrames
+		sdlInit()
+		audioInit()
+		sdlLoop()
+		sdlCleanup()
+	}
+
+	if console.HasBattery() && console.BatteryDirty() {
+		saveBattery()
+	}
+	if err := console.TraceDivergence(); err != nil && !traceDivergenceShown {
+		fmt.Println(err)
+	}
+	if err := closeTrace(); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,134 @@
+package headless
+
+import (
+	"fmt"
+	"github.com/elipsitz/nes-go/nes"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Test ROMs from blargg (and most of nes-test-roms since) report their
+// results in PRG RAM:
+//
+//	$6000       status: $80 running, $81 reset requested, otherwise the result code (0 = passed)
+//	$6001-$6003 $DE $B0 $61, once the above is valid
+//	$6004-      result text, NUL terminated
+//
+// https://github.com/christopherpow/nes-test-roms/blob/master/readme.txt
+
+type TestStatus int
+
+const (
+	TestPassed TestStatus = iota
+	TestFailed
+	TestTimeout
+	TestHalted // the CPU locked up
+)
+
+func (s TestStatus) String() string {
+	switch s {
+	case TestPassed:
+		return "pass"
+	case TestFailed:
+		return "FAIL"
+	case TestHalted:
+		return "HALTED"
+	}
+	return "TIMEOUT"
+}
+
+type TestResult struct {
+	Name   string
+	Status TestStatus
+	Code   byte   // result code written by the ROM (for TestFailed)
+	Text   string // result text written by the ROM
+	Frames int    // frames emulated until the result
+}
+
+const (
+	testRunning        = 0x80
+	testResetRequested = 0x81
+)
+
+// the ROM wants at least 100 ms to pass before the reset button is pressed
+var testResetDelay = 100 * time.Millisecond
+
+// RunTestRom runs a test ROM on `console` until it reports a result or
+// `timeout` frames have passed.
+func RunTestRom(console *nes.Nes, timeout int) TestResult {
+	runner := NewRunner(console)
+	var result TestResult
+	resetFrame := -1 // when the pending reset is due
+	for runner.Frames() < timeout {
+		runner.RunFrame()
+		if err := console.Halted(); err != nil {
+			result.Status = TestHalted
+			result.Text = err.Error()
+			result.Frames = runner.Frames()
+			return result
+		}
+		if !testSignaturePresent(console) {
+			continue
+		}
+		result.Text = testText(console)
+		result.Frames = runner.Frames()
+
+		switch status := console.PeekCPU(0x6000); status {
+		case testRunning:
+			resetFrame = -1
+		case testResetRequested:
+			if resetFrame < 0 {
+				resetFrame = runner.Frames() + int(testResetDelay.Seconds()*console.FrameRate()+1)
+			} else if runner.Frames() >= resetFrame {
+				console.Reset()
+				resetFrame = -1
+			}
+		default:
+			result.Code = status
+			if status == 0 {
+				result.Status = TestPassed
+			} else {
+				result.Status = TestFailed
+			}
+			return result
+		}
+	}
+	result.Status = TestTimeout
+	result.Frames = runner.Frames()
+	return result
+}
+
+func testSignaturePresent(console *nes.Nes) bool {
+	return console.PeekCPU(0x6001) == 0xDE && console.PeekCPU(0x6002) == 0xB0 && console.PeekCPU(0x6003) == 0x61
+}
+
+func testText(console *nes.Nes) string {
+	var text []byte
+	for addr := uint16(0x6004); addr <= 0x7FFF; addr++ {
+		c := console.PeekCPU(addr)
+		if c == 0 {
+			break
+		}
+		text = append(text, c)
+	}
+	return string(text)
+}
+
+// WriteTestSummary writes a table of results, one ROM per line.
+func WriteTestSummary(w io.Writer, results []TestResult) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	passed := 0
+	for _, r := range results {
+		if r.Status == TestPassed {
+			passed++
+		}
+		code := ""
+		if r.Status == TestFailed {
+			code = fmt.Sprintf("#%d", r.Code)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d frames\n", r.Name, r.Status, code, r.Frames)
+	}
+	fmt.Fprintf(tw, "%d/%d passed\n", passed, len(results))
+	return tw.Flush()
+}
@@ -0,0 +1,83 @@
+package headless
+
+import (
+	"github.com/elipsitz/nes-go/nes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testRomDir = "../roms/test"
+
+// most tests finish in well under 30 seconds
+const testRomTimeout = 60 * 60
+
+func findTestRoms(t *testing.T) []string {
+	var paths []string
+	err := filepath.Walk(testRomDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.EqualFold(filepath.Ext(path), ".nes") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		t.Skipf("no test ROMs in %s", testRomDir)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestRoms(t *testing.T) {
+	paths := findTestRoms(t)
+	results := make([]TestResult, len(paths))
+	var mu sync.Mutex
+
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		WriteTestSummary(os.Stdout, results)
+	})
+
+	for i, path := range paths {
+		i, path := i, path
+		name, _ := filepath.Rel(testRomDir, path)
+		results[i] = TestResult{Name: name, Status: TestFailed}
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			cartridge, err := nes.LoadCartridgeFile(path)
+			var console *nes.Nes
+			if err == nil {
+				console, err = nes.NewNes(cartridge)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := RunTestRom(console, testRomTimeout)
+			result.Name = name
+			mu.Lock()
+			results[i] = result
+			mu.Unlock()
+
+			switch result.Status {
+			case TestFailed:
+				t.Errorf("failed with code %d:\n%s", result.Code, result.Text)
+			case TestHalted:
+				t.Errorf("%s", result.Text)
+			case TestTimeout:
+				t.Errorf("no result after %d frames:\n%s", result.Frames, result.Text)
+			default:
+				t.Log(result.Text)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// Package headless runs the emulator without a display or audio device,
+// for regression tests and CI.
+package headless
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/elipsitz/nes-go/nes"
+	"hash"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+const width = 256
+const height = 240
+
+// audio is generated at a fixed rate so the hash doesn't depend on the host
+const sampleRate = 44100
+
+// Runner drives a console frame by frame, keeping the last frame and
+// hashing everything the console outputs.
+type Runner struct {
+	console *nes.Nes
+	script  []InputStep
+	step    int // index of the script step in effect
+	frames  int // frames emulated by this runner
+	buttons [2][8]bool
+
+	drawing   *image.RGBA // frame being drawn (pixels persist while rendering is off)
+	lastFrame *image.RGBA // last complete frame
+
+	ntsc      *nes.NtscFilter // optional
+	ntscFrame *image.RGBA     // last complete frame, filtered
+
+	videoHash hash.Hash
+	audioHash hash.Hash
+	sample    [2]byte
+}
+
+// NewRunner attaches a runner to the console as its video, audio and input.
+func NewRunner(console *nes.Nes) *Runner {
+	r := &Runner{
+		console:   console,
+		step:      -1,
+		drawing:   image.NewRGBA(image.Rect(0, 0, width, height)),
+		lastFrame: image.NewRGBA(image.Rect(0, 0, width, height)),
+		videoHash: sha1.New(),
+		audioHash: sha1.New(),
+	}
+	console.SetVideo(r)
+	console.SetAudio(r)
+	console.SetInput(r)
+	console.SetAudioSampleRate(sampleRate)
+	return r
+}
+
+// SetScript sets the controller input, with frame numbers counted from the
+// runner's first frame.
+func (r *Runner) SetScript(steps []InputStep) {
+	r.script = steps
+}
+
+// SetNtscFilter makes Image and WritePNG return the frame as filtered by f
+// (nes.NtscWidth pixels wide), or unfiltered if f is nil. The video hash is
+// always of the unfiltered frames.
+func (r *Runner) SetNtscFilter(f *nes.NtscFilter) {
+	r.ntsc = f
+	if f != nil {
+		r.ntscFrame = image.NewRGBA(image.Rect(0, 0, nes.NtscWidth, height))
+	}
+}
+
+// Run emulates `frames` more frames.
+func (r *Runner) Run(frames int) {
+	for i := 0; i < frames; i++ {
+		r.RunFrame()
+	}
+}
+
+// RunFrame applies the input script and emulates a single frame.
+func (r *Runner) RunFrame() {
+	for r.step+1 < len(r.script) && r.script[r.step+1].Frame <= r.frames {
+		r.step++
+		r.buttons = r.script[r.step].Buttons
+	}
+	r.console.EmulateFrame()
+	r.frames++
+}
+
+// Frames returns the number of frames emulated by this runner.
+func (r *Runner) Frames() int {
+	return r.frames
+}
+
+// Image returns the last complete frame.
+func (r *Runner) Image() *image.RGBA {
+	if r.ntsc != nil {
+		return r.ntscFrame
+	}
+	return r.lastFrame
+}
+
+// WritePNG encodes the last complete frame as a PNG.
+func (r *Runner) WritePNG(w io.Writer) error {
+	return png.Encode(w, r.Image())
+}
+
+// VideoHash returns a hash of every frame completed so far.
+func (r *Runner) VideoHash() string {
+	return hex.EncodeToString(r.videoHash.Sum(nil))
+}
+
+// AudioHash returns a hash of the audio produced so far, as 16-bit samples.
+func (r *Runner) AudioHash() string {
+	return hex.EncodeToString(r.audioHash.Sum(nil))
+}
+
+func (r *Runner) Buttons(port int) [8]bool {
+	return r.buttons[port]
+}
+
+func (r *Runner) PushPixel(x int, y int, c nes.Color) {
+	r.drawing.SetRGBA(x, y, color.RGBA{byte(c >> 16), byte(c >> 8), byte(c), 0xFF})
+}
+
+func (r *Runner) PushRawPixel(x int, y int, pixel uint16, phase int) {
+	if r.ntsc != nil {
+		r.ntsc.PushRawPixel(x, y, pixel, phase)
+	}
+}
+
+func (r *Runner) PushFrame() {
+	r.videoHash.Write(r.drawing.Pix)
+	copy(r.lastFrame.Pix, r.drawing.Pix)
+	if r.ntsc != nil {
+		for i, c := range r.ntsc.Render() {
+			r.ntscFrame.Pix[i*4], r.ntscFrame.Pix[i*4+1], r.ntscFrame.Pix[i*4+2], r.ntscFrame.Pix[i*4+3] = byte(c>>16), byte(c>>8), byte(c), 0xFF
+		}
+	}
+}
+
+func (r *Runner) PushSample(sample float32) {
+	// quantizing hides differences in the last bits of float rounding between platforms
+	v := int16(math.Max(-1, math.Min(1, float64(sample))) * 32767)
+	r.sample[0], r.sample[1] = byte(v), byte(v>>8)
+	r.audioHash.Write(r.sample[:])
+}
@@ -0,0 +1,81 @@
+package headless
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/elipsitz/nes-go/nes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// InputStep holds the controllers from Frame on, until the next step.
+type InputStep struct {
+	Frame   int
+	Buttons [2][8]bool
+}
+
+var buttonNames = map[string]int{
+	"a":      nes.ButtonA,
+	"b":      nes.ButtonB,
+	"select": nes.ButtonSelect,
+	"start":  nes.ButtonStart,
+	"up":     nes.ButtonUp,
+	"down":   nes.ButtonDown,
+	"left":   nes.ButtonLeft,
+	"right":  nes.ButtonRight,
+}
+
+// ParseScript reads an input script. Each line is a frame number followed by
+// the buttons held on controller 1 and optionally controller 2, e.g.
+//
+//	# press start, then walk right while holding B
+//	60  start
+//	62  -
+//	120 right+b
+//
+// Buttons are joined with '+', "-" means none. Frames must be increasing.
+func ParseScript(r io.Reader) ([]InputStep, error) {
+	var steps []InputStep
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("input script line %d: too many fields", lineNum)
+		}
+
+		var step InputStep
+		var err error
+		step.Frame, err = strconv.Atoi(fields[0])
+		if err != nil || step.Frame < 0 {
+			return nil, fmt.Errorf("input script line %d: bad frame number %q", lineNum, fields[0])
+		}
+		if len(steps) > 0 && step.Frame <= steps[len(steps)-1].Frame {
+			return nil, fmt.Errorf("input script line %d: frame %d is not after the previous step", lineNum, step.Frame)
+		}
+		for port, field := range fields[1:] {
+			if field == "-" {
+				continue
+			}
+			for _, name := range strings.Split(field, "+") {
+				button, ok := buttonNames[strings.ToLower(name)]
+				if !ok {
+					return nil, fmt.Errorf("input script line %d: unknown button %q", lineNum, name)
+				}
+				step.Buttons[port][button] = true
+			}
+		}
+		steps = append(steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
@@ -0,0 +1,51 @@
+package headless
+
+import (
+	"github.com/elipsitz/nes-go/nes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseScript(t *testing.T) {
+	script := `
+# press start, then walk right while holding B
+60  start
+62  -       # released
+120 Right+b a
+`
+	steps, err := ParseScript(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []InputStep{{Frame: 60}, {Frame: 62}, {Frame: 120}}
+	want[0].Buttons[0][nes.ButtonStart] = true
+	want[2].Buttons[0][nes.ButtonRight] = true
+	want[2].Buttons[0][nes.ButtonB] = true
+	want[2].Buttons[1][nes.ButtonA] = true
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got %v, want %v", steps, want)
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		error  string
+	}{
+		{"bad frame", "x start", "line 1: bad frame number"},
+		{"negative frame", "-1 start", "line 1: bad frame number"},
+		{"frames out of order", "10 a\n\n10 b", "line 3: frame 10 is not after"},
+		{"unknown button", "10 a+turbo", `line 1: unknown button "turbo"`},
+		{"too many fields", "10 a b c", "line 1: too many fields"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseScript(strings.NewReader(test.script))
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("got error %v, want %q", err, test.error)
+			}
+		})
+	}
+}
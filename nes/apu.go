@@ -0,0 +1,899 @@
+package nes
+
+import "math"
+
+// https://wiki.nesdev.com/w/index.php/APU
+
+var apuLengthTable = [32]byte{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+var apuDutyTable = [4][8]byte{
+	{0, 1, 0, 0, 0, 0, 0, 0},
+	{0, 1, 1, 0, 0, 0, 0, 0},
+	{0, 1, 1, 1, 1, 0, 0, 0},
+	{1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+var apuTriangleTable = [32]byte{
+	15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0,
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// periods are in CPU cycles
+var apuNoiseTableNTSC = [16]uint16{
+	4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068,
+}
+
+var apuNoiseTablePAL = [16]uint16{
+	4, 8, 14, 30, 60, 88, 118, 148, 188, 236, 354, 472, 708, 944, 1890, 3778,
+}
+
+var apuDmcTableNTSC = [16]uint16{
+	428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54,
+}
+
+var apuDmcTablePAL = [16]uint16{
+	398, 354, 316, 298, 276, 236, 210, 198, 176, 148, 132, 118, 98, 78, 66, 50,
+}
+
+// frame counter steps, in CPU cycles
+type apuFrameTiming struct {
+	step1 int // quarter frame
+	step2 int // quarter and half frame
+	step3 int // quarter frame
+	step4 int // quarter and half frame (4-step mode)
+	step5 int // quarter and half frame (5-step mode)
+}
+
+var apuFrameTimingNTSC = apuFrameTiming{7457, 14913, 22371, 29829, 37281}
+var apuFrameTimingPAL = apuFrameTiming{8313, 16627, 24939, 33253, 41565}
+
+// non-linear mixer lookup tables
+// https://wiki.nesdev.com/w/index.php/APU_Mixer#Lookup_Table
+var apuPulseMixTable [31]float32
+var apuTndMixTable [203]float32
+
+func init() {
+	for i := 1; i < len(apuPulseMixTable); i++ {
+		apuPulseMixTable[i] = float32(95.52 / (8128.0/float64(i) + 100))
+	}
+	for i := 1; i < len(apuTndMixTable); i++ {
+		apuTndMixTable[i] = float32(163.67 / (24329.0/float64(i) + 100))
+	}
+}
+
+type Apu struct {
+	nes *Nes
+
+	pulse1   ApuPulse
+	pulse2   ApuPulse
+	triangle ApuTriangle
+	noise    ApuNoise
+	dmc      ApuDmc
+
+	cycles uint64
+
+	// region dependent timing
+	cpuFrequency float64
+	frameTiming  *apuFrameTiming
+
+	// frame counter
+	frameCycle      int
+	frameMode       byte // 0: 4-step, 1: 5-step
+	frameIrqInhibit bool
+	frameIrqFlag    bool
+	frameResetDelay int
+
+	// output sampling
+	sampleRate  float64
+	sampleRatio float64 // fine adjustment of the rate, for frontends doing rate control
+	sampleTimer float64
+	sampleSum   float32
+	sampleCount int
+	filterChain [3]apuFilter
+}
+
+func NewApu(nes *Nes) *Apu {
+	apu := &Apu{
+		nes:    nes,
+		pulse1: ApuPulse{channel: 1},
+		pulse2: ApuPulse{channel: 2},
+		noise:  ApuNoise{shiftRegister: 1},
+		dmc:    ApuDmc{bitsRemaining: 8, silence: true, bufferEmpty: true},
+	}
+	apu.setRegion(RegionNTSC)
+	apu.noise.timerPeriod = apu.noise.periodTable[0]
+	apu.dmc.timerPeriod = apu.dmc.periodTable[0]
+	apu.SetSampleRate(44100)
+	return apu
+}
+
+func (apu *Apu) setRegion(region Region) {
+	switch region {
+	case RegionPAL:
+		apu.cpuFrequency = 1662607
+		apu.frameTiming = &apuFrameTimingPAL
+		apu.noise.periodTable = &apuNoiseTablePAL
+		apu.dmc.periodTable = &apuDmcTablePAL
+	default:
+		// the Dendy has NTSC APU timing, just with a slower clock
+		apu.cpuFrequency = 1789773
+		if region == RegionDendy {
+			apu.cpuFrequency = 1773448
+		}
+		apu.frameTiming = &apuFrameTimingNTSC
+		apu.noise.periodTable = &apuNoiseTableNTSC
+		apu.dmc.periodTable = &apuDmcTableNTSC
+	}
+}
+
+// SetSampleRate changes the rate (in Hz) at which samples are pushed to the Audio output.
+func (apu *Apu) SetSampleRate(rate float64) {
+	apu.sampleRate = rate
+	apu.sampleRatio = 1
+	apu.sampleTimer = 0
+	apu.sampleSum = 0
+	apu.sampleCount = 0
+	// same filters as the hardware output path
+	// https://wiki.nesdev.com/w/index.php/APU_Mixer
+	apu.filterChain[0] = newHighPassFilter(rate, 90)
+	apu.filterChain[1] = newHighPassFilter(rate, 440)
+	apu.filterChain[2] = newLowPassFilter(rate, 14000)
+}
+
+// SetRateAdjustment scales the output sample rate by `ratio` without resetting the filters.
+func (apu *Apu) SetRateAdjustment(ratio float64) {
+	apu.sampleRatio = ratio
+}
+
+func (apu *Apu) ReadRegister(addr address) byte {
+	switch addr {
+	case 0x4015:
+		// status
+		var status byte
+		if apu.pulse1.lengthValue > 0 {
+			status |= 0x01
+		}
+		if apu.pulse2.lengthValue > 0 {
+			status |= 0x02
+		}
+		if apu.triangle.lengthValue > 0 {
+			status |= 0x04
+		}
+		if apu.noise.lengthValue > 0 {
+			status |= 0x08
+		}
+		if apu.dmc.bytesRemaining > 0 {
+			status |= 0x10
+		}
+		if apu.frameIrqFlag {
+			status |= 0x40
+		}
+		if apu.dmc.irqFlag {
+			status |= 0x80
+		}
+		// bit 5 isn't driven
+		status |= apu.nes.cpu.openBus & 0x20
+		// reading status acknowledges the frame interrupt
+		apu.frameIrqFlag = false
+		return status
+	}
+	return apu.nes.cpu.openBus
+}
+
+func (apu *Apu) WriteRegister(addr address, data byte) {
+	switch {
+	case addr <= 0x4003:
+		apu.pulse1.writeRegister(addr&0x3, data)
+	case addr <= 0x4007:
+		apu.pulse2.writeRegister(addr&0x3, data)
+	case addr <= 0x400B:
+		apu.triangle.writeRegister(addr&0x3, data)
+	case addr <= 0x400F:
+		apu.noise.writeRegister(addr&0x3, data)
+	case addr <= 0x4013:
+		apu.dmc.writeRegister(addr&0x3, data)
+	case addr == 0x4015:
+		// channel enable
+		apu.pulse1.setEnabled(data&0x01 != 0)
+		apu.pulse2.setEnabled(data&0x02 != 0)
+		apu.triangle.setEnabled(data&0x04 != 0)
+		apu.noise.setEnabled(data&0x08 != 0)
+		apu.dmc.setEnabled(data&0x10 != 0)
+		apu.dmc.irqFlag = false
+	case addr == 0x4017:
+		// frame counter
+		apu.frameMode = data >> 7
+		apu.frameIrqInhibit = data&0x40 != 0
+		if apu.frameIrqInhibit {
+			apu.frameIrqFlag = false
+		}
+		// the sequencer is reset 3 or 4 CPU cycles after the write, depending
+		// on whether it lands on an APU cycle
+		if apu.cycles%2 == 0 {
+			apu.frameResetDelay = 3
+		} else {
+			apu.frameResetDelay = 4
+		}
+	}
+}
+
+// https://wiki.nesdev.com/w/index.php/CPU_power_up_state#After_reset
+func (apu *Apu) reset() {
+	// silenced, as if $4015 was written with 0
+	apu.WriteRegister(0x4015, 0)
+	apu.frameIrqFlag = false
+	apu.triangle.sequence = 0
+	apu.dmc.outputLevel &= 1
+
+	// the frame counter restarts, as if $4017 was written with its last value
+	var frameControl byte = apu.frameMode << 7
+	if apu.frameIrqInhibit {
+		frameControl |= 0x40
+	}
+	apu.WriteRegister(0x4017, frameControl)
+}
+
+// emulate for `cycles` CPU cycles
+func (apu *Apu) Emulate(cycles int) {
+	for i := 0; i < cycles; i++ {
+		apu.cycles++
+
+		// the triangle timer runs at the CPU clock; pulse timers at half of it
+		apu.triangle.clockTimer()
+		if apu.cycles%2 == 0 {
+			apu.pulse1.clockTimer()
+			apu.pulse2.clockTimer()
+		}
+		apu.noise.clockTimer()
+		apu.dmc.clockTimer(apu)
+
+		apu.clockFrameCounter()
+		apu.sample()
+
+		apu.nes.cpu.setIRQ(irqSourceFrameCounter, apu.frameIrqFlag)
+		apu.nes.cpu.setIRQ(irqSourceDMC, apu.dmc.irqFlag)
+	}
+}
+
+// sequence steps are in CPU cycles
+// https://wiki.nesdev.com/w/index.php/APU_Frame_Counter
+func (apu *Apu) clockFrameCounter() {
+	if apu.frameResetDelay > 0 {
+		apu.frameResetDelay--
+		if apu.frameResetDelay == 0 {
+			apu.frameCycle = 0
+			if apu.frameMode == 1 {
+				// 5-step mode immediately clocks all units
+				apu.clockQuarterFrame()
+				apu.clockHalfFrame()
+			}
+		}
+	}
+
+	apu.frameCycle++
+	t := apu.frameTiming
+	if apu.frameMode == 0 {
+		// 4-step sequence
+		switch apu.frameCycle {
+		case t.step1, t.step3:
+			apu.clockQuarterFrame()
+		case t.step2:
+			apu.clockQuarterFrame()
+			apu.clockHalfFrame()
+		case t.step4 - 1:
+			apu.setFrameIrq()
+		case t.step4:
+			apu.clockQuarterFrame()
+			apu.clockHalfFrame()
+			apu.setFrameIrq()
+		case t.step4 + 1:
+			apu.setFrameIrq()
+			apu.frameCycle = 0
+		}
+	} else {
+		// 5-step sequence
+		switch apu.frameCycle {
+		case t.step1, t.step3:
+			apu.clockQuarterFrame()
+		case t.step2, t.step5:
+			apu.clockQuarterFrame()
+			apu.clockHalfFrame()
+		case t.step5 + 1:
+			apu.frameCycle = 0
+		}
+	}
+}
+
+func (apu *Apu) setFrameIrq() {
+	if !apu.frameIrqInhibit {
+		apu.frameIrqFlag = true
+	}
+}
+
+func (apu *Apu) clockQuarterFrame() {
+	apu.pulse1.envelope.clock()
+	apu.pulse2.envelope.clock()
+	apu.triangle.clockLinearCounter()
+	apu.noise.envelope.clock()
+}
+
+func (apu *Apu) clockHalfFrame() {
+	apu.pulse1.clockLength()
+	apu.pulse1.clockSweep()
+	apu.pulse2.clockLength()
+	apu.pulse2.clockSweep()
+	apu.triangle.clockLength()
+	apu.noise.clockLength()
+}
+
+func (apu *Apu) mix() float32 {
+	pulse := apuPulseMixTable[apu.pulse1.output()+apu.pulse2.output()]
+	tnd := apuTndMixTable[3*int(apu.triangle.output())+2*int(apu.noise.output())+int(apu.dmc.output())]
+	return pulse + tnd
+}
+
+func (apu *Apu) sample() {
+	// average every CPU cycle's output over the sample period (box filter)
+	apu.sampleSum += apu.mix()
+	apu.sampleCount++
+
+	apu.sampleTimer += apu.sampleRate * apu.sampleRatio
+	if apu.sampleTimer < apu.cpuFrequency {
+		return
+	}
+	apu.sampleTimer -= apu.cpuFrequency
+
+	out := apu.sampleSum / float32(apu.sampleCount)
+	apu.sampleSum, apu.sampleCount = 0, 0
+	for i := range apu.filterChain {
+		out = apu.filterChain[i].step(out)
+	}
+	if apu.nes.audio != nil {
+		apu.nes.audio.PushSample(out)
+	}
+}
+
+/* ***** ENVELOPE ***** */
+
+type ApuEnvelope struct {
+	start    bool
+	loop     bool
+	constant bool
+	period   byte
+	divider  byte
+	decay    byte
+}
+
+func (e *ApuEnvelope) write(data byte) {
+	e.loop = data&0x20 != 0
+	e.constant = data&0x10 != 0
+	e.period = data & 0x0F
+}
+
+func (e *ApuEnvelope) clock() {
+	if e.start {
+		e.start = false
+		e.decay = 15
+		e.divider = e.period
+		return
+	}
+	if e.divider > 0 {
+		e.divider--
+		return
+	}
+	e.divider = e.period
+	if e.decay > 0 {
+		e.decay--
+	} else if e.loop {
+		e.decay = 15
+	}
+}
+
+func (e *ApuEnvelope) volume() byte {
+	if e.constant {
+		return e.period
+	}
+	return e.decay
+}
+
+/* ***** PULSE ***** */
+
+type ApuPulse struct {
+	channel byte // 1 or 2 (they differ in sweep negation)
+	enabled bool
+
+	dutyMode  byte
+	dutyValue byte
+
+	timerPeriod uint16
+	timerValue  uint16
+
+	lengthHalt  bool
+	lengthValue byte
+
+	envelope ApuEnvelope
+
+	sweepEnabled bool
+	sweepNegate  bool
+	sweepReload  bool
+	sweepPeriod  byte
+	sweepShift   byte
+	sweepDivider byte
+}
+
+func (p *ApuPulse) writeRegister(register address, data byte) {
+	switch register {
+	case 0:
+		p.dutyMode = data >> 6
+		p.lengthHalt = data&0x20 != 0
+		p.envelope.write(data)
+	case 1:
+		p.sweepEnabled = data&0x80 != 0
+		p.sweepPeriod = (data >> 4) & 0x7
+		p.sweepNegate = data&0x08 != 0
+		p.sweepShift = data & 0x7
+		p.sweepReload = true
+	case 2:
+		p.timerPeriod = (p.timerPeriod & 0x700) | uint16(data)
+	case 3:
+		p.timerPeriod = (p.timerPeriod & 0xFF) | (uint16(data&0x7) << 8)
+		if p.enabled {
+			p.lengthValue = apuLengthTable[data>>3]
+		}
+		p.envelope.start = true
+		p.dutyValue = 0
+	}
+}
+
+func (p *ApuPulse) setEnabled(enabled bool) {
+	p.enabled = enabled
+	if !enabled {
+		p.lengthValue = 0
+	}
+}
+
+func (p *ApuPulse) clockTimer() {
+	if p.timerValue == 0 {
+		p.timerValue = p.timerPeriod
+		p.dutyValue = (p.dutyValue + 1) % 8
+	} else {
+		p.timerValue--
+	}
+}
+
+func (p *ApuPulse) clockLength() {
+	if !p.lengthHalt && p.lengthValue > 0 {
+		p.lengthValue--
+	}
+}
+
+func (p *ApuPulse) sweepTarget() uint16 {
+	change := p.timerPeriod >> p.sweepShift
+	if !p.sweepNegate {
+		return p.timerPeriod + change
+	}
+	// pulse 1 uses one's complement
+	if p.channel == 1 {
+		change++
+	}
+	if change > p.timerPeriod {
+		return 0
+	}
+	return p.timerPeriod - change
+}
+
+func (p *ApuPulse) sweepMuting() bool {
+	return p.timerPeriod < 8 || p.sweepTarget() > 0x7FF
+}
+
+func (p *ApuPulse) clockSweep() {
+	if p.sweepDivider == 0 && p.sweepEnabled && p.sweepShift > 0 && !p.sweepMuting() {
+		p.timerPeriod = p.sweepTarget()
+	}
+	if p.sweepDivider == 0 || p.sweepReload {
+		p.sweepDivider = p.sweepPeriod
+		p.sweepReload = false
+	} else {
+		p.sweepDivider--
+	}
+}
+
+func (p *ApuPulse) output() byte {
+	if p.lengthValue == 0 || p.sweepMuting() || apuDutyTable[p.dutyMode][p.dutyValue] == 0 {
+		return 0
+	}
+	return p.envelope.volume()
+}
+
+/* ***** TRIANGLE ***** */
+
+type ApuTriangle struct {
+	enabled bool
+
+	timerPeriod uint16
+	timerValue  uint16
+	sequence    byte
+
+	// the control flag doubles as the length counter halt flag
+	control      bool
+	lengthValue  byte
+	linearPeriod byte
+	linearValue  byte
+	linearReload bool
+}
+
+func (t *ApuTriangle) writeRegister(register address, data byte) {
+	switch register {
+	case 0:
+		t.control = data&0x80 != 0
+		t.linearPeriod = data & 0x7F
+	case 2:
+		t.timerPeriod = (t.timerPeriod & 0x700) | uint16(data)
+	case 3:
+		t.timerPeriod = (t.timerPeriod & 0xFF) | (uint16(data&0x7) << 8)
+		if t.enabled {
+			t.lengthValue = apuLengthTable[data>>3]
+		}
+		t.linearReload = true
+	}
+}
+
+func (t *ApuTriangle) setEnabled(enabled bool) {
+	t.enabled = enabled
+	if !enabled {
+		t.lengthValue = 0
+	}
+}
+
+func (t *ApuTriangle) clockTimer() {
+	if t.timerValue == 0 {
+		t.timerValue = t.timerPeriod
+		if t.lengthValue > 0 && t.linearValue > 0 {
+			t.sequence = (t.sequence + 1) % 32
+		}
+	} else {
+		t.timerValue--
+	}
+}
+
+func (t *ApuTriangle) clockLength() {
+	if !t.control && t.lengthValue > 0 {
+		t.lengthValue--
+	}
+}
+
+func (t *ApuTriangle) clockLinearCounter() {
+	if t.linearReload {
+		t.linearValue = t.linearPeriod
+	} else if t.linearValue > 0 {
+		t.linearValue--
+	}
+	if !t.control {
+		t.linearReload = false
+	}
+}
+
+func (t *ApuTriangle) output() byte {
+	return apuTriangleTable[t.sequence]
+}
+
+/* ***** NOISE ***** */
+
+type ApuNoise struct {
+	enabled     bool
+	periodTable *[16]uint16
+
+	mode          bool
+	shiftRegister uint16
+	timerPeriod   uint16
+	timerValue    uint16
+
+	lengthHalt  bool
+	lengthValue byte
+
+	envelope ApuEnvelope
+}
+
+func (n *ApuNoise) writeRegister(register address, data byte) {
+	switch register {
+	case 0:
+		n.lengthHalt = data&0x20 != 0
+		n.envelope.write(data)
+	case 2:
+		n.mode = data&0x80 != 0
+		n.timerPeriod = n.periodTable[data&0x0F]
+	case 3:
+		if n.enabled {
+			n.lengthValue = apuLengthTable[data>>3]
+		}
+		n.envelope.start = true
+	}
+}
+
+func (n *ApuNoise) setEnabled(enabled bool) {
+	n.enabled = enabled
+	if !enabled {
+		n.lengthValue = 0
+	}
+}
+
+func (n *ApuNoise) clockTimer() {
+	if n.timerValue > 0 {
+		n.timerValue--
+		return
+	}
+	n.timerValue = n.timerPeriod - 1
+
+	var tap uint16 = 1
+	if n.mode {
+		tap = 6
+	}
+	feedback := (n.shiftRegister & 1) ^ ((n.shiftRegister >> tap) & 1)
+	n.shiftRegister = (n.shiftRegister >> 1) | (feedback << 14)
+}
+
+func (n *ApuNoise) clockLength() {
+	if !n.lengthHalt && n.lengthValue > 0 {
+		n.lengthValue--
+	}
+}
+
+func (n *ApuNoise) output() byte {
+	if n.lengthValue == 0 || n.shiftRegister&1 != 0 {
+		return 0
+	}
+	return n.envelope.volume()
+}
+
+/* ***** DMC ***** */
+
+type ApuDmc struct {
+	enabled     bool
+	periodTable *[16]uint16
+
+	irqEnabled bool
+	irqFlag    bool
+	loop       bool
+
+	timerPeriod uint16
+	timerValue  uint16
+
+	// memory reader
+	sampleAddress  address
+	sampleLength   uint16
+	currentAddress address
+	bytesRemaining uint16
+	sampleBuffer   byte
+	bufferEmpty    bool
+
+	// output unit
+	shiftRegister byte
+	bitsRemaining byte
+	silence       bool
+	outputLevel   byte
+}
+
+func (d *ApuDmc) writeRegister(register address, data byte) {
+	switch register {
+	case 0:
+		d.irqEnabled = data&0x80 != 0
+		d.loop = data&0x40 != 0
+		d.timerPeriod = d.periodTable[data&0x0F]
+		if !d.irqEnabled {
+			d.irqFlag = false
+		}
+	case 1:
+		d.outputLevel = data & 0x7F
+	case 2:
+		d.sampleAddress = 0xC000 | (address(data) << 6)
+	case 3:
+		d.sampleLength = (uint16(data) << 4) | 1
+	}
+}
+
+func (d *ApuDmc) setEnabled(enabled bool) {
+	d.enabled = enabled
+	if !enabled {
+		d.bytesRemaining = 0
+	} else if d.bytesRemaining == 0 {
+		d.restart()
+	}
+}
+
+func (d *ApuDmc) restart() {
+	d.currentAddress = d.sampleAddress
+	d.bytesRemaining = d.sampleLength
+}
+
+// the memory reader fetches the next sample byte with DMA, stealing cycles from the CPU
+func (d *ApuDmc) fillBuffer(apu *Apu) {
+	if d.bufferEmpty && d.bytesRemaining > 0 {
+		apu.nes.cpu.startDmcDma()
+	}
+}
+
+// the byte fetched by DMA
+func (d *ApuDmc) loadSample(data byte) {
+	if !d.bufferEmpty || d.bytesRemaining == 0 {
+		// disabled while the fetch was waiting
+		return
+	}
+	d.sampleBuffer = data
+	d.bufferEmpty = false
+
+	if d.currentAddress == 0xFFFF {
+		d.currentAddress = 0x8000
+	} else {
+		d.currentAddress++
+	}
+	d.bytesRemaining--
+	if d.bytesRemaining == 0 {
+		if d.loop {
+			d.restart()
+		} else if d.irqEnabled {
+			d.irqFlag = true
+		}
+	}
+}
+
+func (d *ApuDmc) clockTimer(apu *Apu) {
+	d.fillBuffer(apu)
+
+	if d.timerValue > 0 {
+		d.timerValue--
+		return
+	}
+	d.timerValue = d.timerPeriod - 1
+
+	if !d.silence {
+		if d.shiftRegister&1 != 0 {
+			if d.outputLevel <= 125 {
+				d.outputLevel += 2
+			}
+		} else {
+			if d.outputLevel >= 2 {
+				d.outputLevel -= 2
+			}
+		}
+	}
+	d.shiftRegister >>= 1
+
+	d.bitsRemaining--
+	if d.bitsRemaining == 0 {
+		d.bitsRemaining = 8
+		if d.bufferEmpty {
+			d.silence = true
+		} else {
+			d.silence = false
+			d.shiftRegister = d.sampleBuffer
+			d.bufferEmpty = true
+			d.fillBuffer(apu)
+		}
+	}
+}
+
+func (d *ApuDmc) output() byte {
+	return d.outputLevel
+}
+
+/* ***** OUTPUT FILTERS ***** */
+
+// first order IIR filter
+type apuFilter struct {
+	b0, b1, a1   float32
+	prevX, prevY float32
+}
+
+func newLowPassFilter(sampleRate float64, cutoff float64) apuFilter {
+	c := sampleRate / (math.Pi * cutoff)
+	a0i := 1 / (1 + c)
+	return apuFilter{
+		b0: float32(a0i),
+		b1: float32(a0i),
+		a1: float32((1 - c) * a0i),
+	}
+}
+
+func newHighPassFilter(sampleRate float64, cutoff float64) apuFilter {
+	c := sampleRate / (math.Pi * cutoff)
+	a0i := 1 / (1 + c)
+	return apuFilter{
+		b0: float32(c * a0i),
+		b1: float32(-c * a0i),
+		a1: float32((1 - c) * a0i),
+	}
+}
+
+func (f *apuFilter) step(x float32) float32 {
+	y := f.b0*x + f.b1*f.prevX - f.a1*f.prevY
+	f.prevX, f.prevY = x, y
+	return y
+}
+
+/* ***** SAVE STATES ***** */
+
+func (apu *Apu) Serialize(s *StateStream) {
+	apu.pulse1.serialize(s)
+	apu.pulse2.serialize(s)
+	apu.triangle.serialize(s)
+	apu.noise.serialize(s)
+	apu.dmc.serialize(s)
+
+	s.Uint64(&apu.cycles)
+	s.Int(&apu.frameCycle)
+	s.Byte(&apu.frameMode)
+	s.Bool(&apu.frameIrqInhibit)
+	s.Bool(&apu.frameIrqFlag)
+	s.Int(&apu.frameResetDelay)
+}
+
+func (e *ApuEnvelope) serialize(s *StateStream) {
+	s.Bool(&e.start)
+	s.Bool(&e.loop)
+	s.Bool(&e.constant)
+	s.Byte(&e.period)
+	s.Byte(&e.divider)
+	s.Byte(&e.decay)
+}
+
+func (p *ApuPulse) serialize(s *StateStream) {
+	s.Bool(&p.enabled)
+	s.Byte(&p.dutyMode)
+	s.Byte(&p.dutyValue)
+	s.Uint16(&p.timerPeriod)
+	s.Uint16(&p.timerValue)
+	s.Bool(&p.lengthHalt)
+	s.Byte(&p.lengthValue)
+	p.envelope.serialize(s)
+	s.Bool(&p.sweepEnabled)
+	s.Bool(&p.sweepNegate)
+	s.Bool(&p.sweepReload)
+	s.Byte(&p.sweepPeriod)
+	s.Byte(&p.sweepShift)
+	s.Byte(&p.sweepDivider)
+}
+
+func (t *ApuTriangle) serialize(s *StateStream) {
+	s.Bool(&t.enabled)
+	s.Uint16(&t.timerPeriod)
+	s.Uint16(&t.timerValue)
+	s.Byte(&t.sequence)
+	s.Bool(&t.control)
+	s.Byte(&t.lengthValue)
+	s.Byte(&t.linearPeriod)
+	s.Byte(&t.linearValue)
+	s.Bool(&t.linearReload)
+}
+
+func (n *ApuNoise) serialize(s *StateStream) {
+	s.Bool(&n.enabled)
+	s.Bool(&n.mode)
+	s.Uint16(&n.shiftRegister)
+	s.Uint16(&n.timerPeriod)
+	s.Uint16(&n.timerValue)
+	s.Bool(&n.lengthHalt)
+	s.Byte(&n.lengthValue)
+	n.envelope.serialize(s)
+}
+
+func (d *ApuDmc) serialize(s *StateStream) {
+	s.Bool(&d.enabled)
+	s.Bool(&d.irqEnabled)
+	s.Bool(&d.irqFlag)
+	s.Bool(&d.loop)
+	s.Uint16(&d.timerPeriod)
+	s.Uint16(&d.timerValue)
+	s.Address(&d.sampleAddress)
+	s.Uint16(&d.sampleLength)
+	s.Address(&d.currentAddress)
+	s.Uint16(&d.bytesRemaining)
+	s.Byte(&d.sampleBuffer)
+	s.Bool(&d.bufferEmpty)
+	s.Byte(&d.shiftRegister)
+	s.Byte(&d.bitsRemaining)
+	s.Bool(&d.silence)
+	s.Byte(&d.outputLevel)
+}
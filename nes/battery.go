@@ -0,0 +1,54 @@
+package nes
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Mappers with PRG RAM at $6000-$7FFF implement this, so it can be persisted
+// when the cartridge has a battery.
+type PrgRamMapper interface {
+	PrgRam() []byte
+}
+
+// HasBattery returns whether the cartridge has battery backed PRG RAM that should be persisted.
+func (nes *Nes) HasBattery() bool {
+	_, ok := nes.mapper.(PrgRamMapper)
+	return nes.cartridge.battery && ok && nes.cartridge.prgNvramSize > 0
+}
+
+// the battery backed part of the PRG RAM, which comes first if there's volatile RAM too
+func (nes *Nes) batteryRam() []byte {
+	return nes.mapper.(PrgRamMapper).PrgRam()[:nes.cartridge.prgNvramSize]
+}
+
+// LoadBattery restores the battery backed PRG RAM from a .sav file.
+func (nes *Nes) LoadBattery(r io.Reader) error {
+	ram := nes.batteryRam()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) != len(ram) {
+		return fmt.Errorf("save file is %d bytes, expected %d", len(data), len(ram))
+	}
+	copy(ram, data)
+	nes.batterySaved = append(nes.batterySaved[:0], ram...)
+	return nil
+}
+
+// SaveBattery writes out the battery backed PRG RAM in .sav format.
+func (nes *Nes) SaveBattery(w io.Writer) error {
+	ram := nes.batteryRam()
+	if _, err := w.Write(ram); err != nil {
+		return err
+	}
+	nes.batterySaved = append(nes.batterySaved[:0], ram...)
+	return nil
+}
+
+// BatteryDirty returns whether the PRG RAM changed since it was last loaded or saved.
+func (nes *Nes) BatteryDirty() bool {
+	return !bytes.Equal(nes.batteryRam(), nes.batterySaved)
+}
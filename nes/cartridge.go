@@ -0,0 +1,343 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// https://wiki.nesdev.com/w/index.php/INES
+// https://wiki.nesdev.com/w/index.php/NES_2.0
+type INesHeader struct {
+	MagicNumber uint32
+	SizeRomPRG  byte
+	SizeRomCHR  byte
+	Flag6       byte
+	Flag7       byte
+	SizeRamPRG  byte    // NES 2.0: mapper MSB / submapper
+	ExtraFlags  [7]byte // NES 2.0: bytes 9 to 15
+}
+
+// CPU/PPU timing
+const (
+	TimingNTSC  = 0
+	TimingPAL   = 1
+	TimingMulti = 2 // works on both NTSC and PAL machines
+	TimingDendy = 3
+)
+
+// console types
+const (
+	ConsoleNES        = 0 // or Famicom
+	ConsoleVsSystem   = 1
+	ConsolePlaychoice = 2
+	ConsoleExtended   = 3 // NES 2.0 only: the actual type (3 and up) is in byte 13, and stored as-is
+)
+
+// ErrBadMagic is returned when the file doesn't start with "NES\x1A"
+var ErrBadMagic = errors.New("not an iNES file")
+
+// TruncatedError is returned when the file ends before a part of it the header promised.
+type TruncatedError struct {
+	Section  string // "header", "trainer", "PRG ROM" or "CHR ROM"
+	Expected int
+	Actual   int
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("truncated %s: expected %d bytes, got %d", e.Section, e.Expected, e.Actual)
+}
+
+// UnsupportedMapperError is returned for cartridges using a mapper that isn't implemented.
+type UnsupportedMapperError struct {
+	MapperID  int
+	Submapper int
+}
+
+func (e *UnsupportedMapperError) Error() string {
+	return fmt.Sprintf("unsupported mapper: %d (submapper %d)", e.MapperID, e.Submapper)
+}
+
+// UnsupportedFeatureError is returned for headers (mostly NES 2.0 ones) describing
+// hardware we can't emulate.
+type UnsupportedFeatureError struct {
+	Feature string
+}
+
+func (e *UnsupportedFeatureError) Error() string {
+	return "unsupported cartridge feature: " + e.Feature
+}
+
+// ROMs larger than this are rejected rather than allocated
+const maxRomSize = 64 * 1024 * 1024
+
+type Cartridge struct {
+	header     INesHeader
+	prg        []byte
+	chr        []byte
+	chrRam     bool // chr is RAM rather than ROM
+	mapperID   int
+	mirrorMode int
+	battery    bool // PRG RAM is battery backed
+
+	// from NES 2.0 headers (with iNES defaults otherwise)
+	nes2            bool
+	submapper       int
+	prgRamSize      int // volatile PRG RAM, in bytes
+	prgNvramSize    int // battery backed PRG RAM, in bytes
+	chrRamSize      int
+	chrNvramSize    int
+	timing          int
+	consoleType     int
+	vsPpuType       int
+	vsHardwareType  int
+	miscRomCount    int
+	expansionDevice int // https://wiki.nesdev.com/w/index.php/NES_2.0#Default_Expansion_Device
+}
+
+func LoadCartridgeFile(path string) (*Cartridge, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadCartridge(f)
+}
+
+func LoadCartridge(r io.Reader) (*Cartridge, error) {
+	c := Cartridge{}
+	c.header = INesHeader{}
+	var headerBytes [16]byte
+	if err := readSection(r, headerBytes[:], "header"); err != nil {
+		return nil, err
+	}
+	binary.Read(bytes.NewReader(headerBytes[:]), binary.LittleEndian, &c.header)
+
+	if c.header.MagicNumber != 0x1a53454e {
+		return nil, ErrBadMagic
+	}
+
+	c.mirrorMode = int((c.header.Flag6 & 0x1) | (c.header.Flag6 & 0x8 >> 2))
+	c.battery = c.header.Flag6&0x2 != 0
+	c.nes2 = c.header.Flag7&0x0C == 0x08
+
+	var prgSize, chrSize int
+	if c.nes2 {
+		prgSize, chrSize = c.decodeNes2Header()
+		if err := c.checkNes2Support(prgSize, chrSize); err != nil {
+			return nil, err
+		}
+	} else {
+		prgSize, chrSize = c.decodeINesHeader()
+	}
+	if prgSize == 0 {
+		// mappers mirror smaller ROMs to fill their banks, but there has to be something
+		return nil, &UnsupportedFeatureError{"no PRG ROM"}
+	}
+
+	// read and discard trainer
+	if c.header.Flag6&0x4 > 0 {
+		if err := readSection(r, make([]byte, 512), "trainer"); err != nil {
+			return nil, err
+		}
+	}
+
+	// read PRG rom
+	c.prg = make([]byte, prgSize)
+	if err := readSection(r, c.prg, "PRG ROM"); err != nil {
+		return nil, err
+	}
+
+	// read CHR rom
+	c.chr = make([]byte, chrSize)
+	if err := readSection(r, c.chr, "CHR ROM"); err != nil {
+		return nil, err
+	}
+
+	if chrSize == 0 {
+		// no CHR ROM means the cartridge has CHR RAM instead
+		c.chrRam = true
+		c.chr = make([]byte, c.chrRamSize+c.chrNvramSize)
+		if len(c.chr) == 0 {
+			c.chr = make([]byte, 8192)
+		}
+	}
+
+	return &c, nil
+}
+
+func readSection(r io.Reader, data []byte, section string) error {
+	n, err := io.ReadFull(r, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &TruncatedError{Section: section, Expected: len(data), Actual: n}
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", section, err)
+	}
+	return nil
+}
+
+// returns PRG and CHR ROM sizes, in bytes
+func (c *Cartridge) decodeINesHeader() (int, int) {
+	c.mapperID = int(c.header.Flag6 >> 4)
+	// some old headers have garbage (e.g. "DiskDude!") from byte 7 on: only trust
+	// bytes 7-15 if the padding is clean, otherwise use the defaults
+	padding := c.header.ExtraFlags[3:]
+	clean := padding[0] == 0 && padding[1] == 0 && padding[2] == 0 && padding[3] == 0
+
+	// byte 8 is PRG RAM in 8 KB units, where 0 still means 8 KB
+	prgRam := 8192
+	if clean && c.header.SizeRamPRG > 1 {
+		prgRam = int(c.header.SizeRamPRG) * 8192
+	}
+	if c.battery {
+		c.prgNvramSize = prgRam
+	} else {
+		c.prgRamSize = prgRam
+	}
+	if c.header.SizeRomCHR == 0 {
+		c.chrRamSize = 8192
+	}
+	if clean {
+		c.mapperID |= int(c.header.Flag7 & 0xF0)
+		c.consoleType = int(c.header.Flag7 & 0x3)
+		if c.header.ExtraFlags[0]&0x1 != 0 {
+			c.timing = TimingPAL
+		}
+	}
+
+	return int(c.header.SizeRomPRG) * 16384, int(c.header.SizeRomCHR) * 8192
+}
+
+// returns PRG and CHR ROM sizes, in bytes
+func (c *Cartridge) decodeNes2Header() (int, int) {
+	byte8, extra := c.header.SizeRamPRG, c.header.ExtraFlags
+
+	c.mapperID = int(c.header.Flag6>>4) | int(c.header.Flag7&0xF0) | int(byte8&0x0F)<<8
+	c.submapper = int(byte8 >> 4)
+
+	prgSize := nes2RomSize(c.header.SizeRomPRG, extra[0]&0x0F, 16384)
+	chrSize := nes2RomSize(c.header.SizeRomCHR, extra[0]>>4, 8192)
+
+	c.prgRamSize = nes2RamSize(extra[1] & 0x0F)
+	c.prgNvramSize = nes2RamSize(extra[1] >> 4)
+	c.chrRamSize = nes2RamSize(extra[2] & 0x0F)
+	c.chrNvramSize = nes2RamSize(extra[2] >> 4)
+
+	c.timing = int(extra[3] & 0x3)
+
+	c.consoleType = int(c.header.Flag7 & 0x3)
+	switch c.consoleType {
+	case ConsoleVsSystem:
+		c.vsPpuType = int(extra[4] & 0x0F)
+		c.vsHardwareType = int(extra[4] >> 4)
+	case ConsoleExtended:
+		c.consoleType = int(extra[4] & 0x0F)
+	}
+
+	c.miscRomCount = int(extra[5] & 0x3)
+	c.expansionDevice = int(extra[6] & 0x3F)
+
+	return prgSize, chrSize
+}
+
+func (c *Cartridge) checkNes2Support(prgSize int, chrSize int) error {
+	switch {
+	case c.consoleType == ConsoleVsSystem:
+		return &UnsupportedFeatureError{"Vs. System"}
+	case c.consoleType == ConsolePlaychoice:
+		return &UnsupportedFeatureError{"PlayChoice-10"}
+	case c.consoleType != ConsoleNES:
+		return &UnsupportedFeatureError{fmt.Sprintf("extended console type %d", c.consoleType)}
+	case prgSize > maxRomSize || chrSize > maxRomSize:
+		return &UnsupportedFeatureError{"ROM larger than 64 MB"}
+	case c.miscRomCount > 0:
+		return &UnsupportedFeatureError{"miscellaneous ROMs"}
+	}
+	return nil
+}
+
+// ROM sizes are a 12 bit count of `unit` sized banks, unless the MSB nibble is $F,
+// in which case the LSB is in exponent-multiplier notation: EEEEEEMM = 2^E * (MM*2+1)
+func nes2RomSize(lsb byte, msb byte, unit int) int {
+	if msb == 0xF {
+		exponent := uint(lsb >> 2)
+		multiplier := int(lsb&0x3)*2 + 1
+		if exponent > 32 {
+			// way beyond anything real; make sure it doesn't overflow
+			return maxRomSize + 1
+		}
+		return (1 << exponent) * multiplier
+	}
+	return (int(msb)<<8 | int(lsb)) * unit
+}
+
+// RAM sizes are shift counts: 64 << shift bytes, or nothing if the shift is 0
+func nes2RamSize(shift byte) int {
+	if shift == 0 {
+		return 0
+	}
+	return 64 << shift
+}
+
+func (c *Cartridge) MapperID() int {
+	return c.mapperID
+}
+
+func (c *Cartridge) Submapper() int {
+	return c.submapper
+}
+
+// IsNes2 returns whether the cartridge had a NES 2.0 header.
+func (c *Cartridge) IsNes2() bool {
+	return c.nes2
+}
+
+// Timing returns the CPU/PPU timing the cartridge was made for (TimingNTSC, etc.).
+func (c *Cartridge) Timing() int {
+	return c.timing
+}
+
+// PrgRamSize returns the size of the volatile PRG RAM, in bytes.
+func (c *Cartridge) PrgRamSize() int {
+	return c.prgRamSize
+}
+
+// PrgNvramSize returns the size of the battery backed PRG RAM, in bytes.
+func (c *Cartridge) PrgNvramSize() int {
+	return c.prgNvramSize
+}
+
+// ChrRamSize returns the size of the volatile CHR RAM, in bytes.
+func (c *Cartridge) ChrRamSize() int {
+	return c.chrRamSize
+}
+
+// ChrNvramSize returns the size of the battery backed CHR RAM, in bytes.
+func (c *Cartridge) ChrNvramSize() int {
+	return c.chrNvramSize
+}
+
+// ConsoleType returns the kind of console the cartridge is for (ConsoleNES, etc.).
+func (c *Cartridge) ConsoleType() int {
+	return c.consoleType
+}
+
+// ExpansionDevice returns the NES 2.0 default expansion device (0 if unspecified).
+func (c *Cartridge) ExpansionDevice() int {
+	return c.expansionDevice
+}
+
+// checksums of the ROM contents (CHR RAM isn't included)
+func (cartridge *Cartridge) CRC32() (prg, chr, total uint32) {
+	prg, total = crc32.ChecksumIEEE(cartridge.prg), crc32.ChecksumIEEE(cartridge.prg)
+	if !cartridge.chrRam {
+		chr = crc32.ChecksumIEEE(cartridge.chr)
+		total = crc32.Update(total, crc32.IEEETable, cartridge.chr)
+	}
+	return
+}
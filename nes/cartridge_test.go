@@ -0,0 +1,313 @@
+package nes
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testRomData builds a ROM file from header bytes 4 to 15 (missing ones are 0),
+// followed by zeroed PRG and CHR sections of the given sizes.
+func testRomData(prgSize int, chrSize int, header ...byte) []byte {
+	data := make([]byte, 16+prgSize+chrSize)
+	copy(data, "NES\x1A")
+	copy(data[4:16], header)
+	return data
+}
+
+// newTestNes builds an NROM console that runs `code` from $8000. NMI and IRQ
+// handlers are an RTI at $8100.
+func newTestNes(t *testing.T, code ...byte) *Nes {
+	t.Helper()
+	data := testRomData(16384, 8192, 1, 1)
+	prg := data[16 : 16+16384]
+	copy(prg, code)
+	prg[0x0100] = 0x40 // RTI
+	prg[0x3FFA], prg[0x3FFB] = 0x00, 0x81
+	prg[0x3FFC], prg[0x3FFD] = 0x00, 0x80
+	prg[0x3FFE], prg[0x3FFF] = 0x00, 0x81
+	c, err := LoadCartridge(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	console, err := NewNes(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return console
+}
+
+func TestDecodeINesHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		mapper     int
+		mirror     int
+		battery    bool
+		timing     int
+		prgRam     int
+		prgNvram   int
+		chrRamSize int
+	}{
+		{
+			name:   "NROM",
+			data:   testRomData(32768, 8192, 2, 1, 0x01, 0x00),
+			mapper: 0, mirror: 1, prgRam: 8192,
+		},
+		{
+			name:   "MMC1 with battery and CHR RAM",
+			data:   testRomData(131072, 0, 8, 0, 0x12, 0x00),
+			mapper: 1, battery: true, prgNvram: 8192, chrRamSize: 8192,
+		},
+		{
+			name:   "MMC3, four screen, PAL, 32 KB PRG RAM",
+			data:   testRomData(131072, 131072, 8, 16, 0x48, 0x00, 4, 1),
+			mapper: 4, mirror: 2, timing: TimingPAL, prgRam: 32768,
+		},
+		{
+			name:   "upper mapper nibble",
+			data:   testRomData(16384, 8192, 1, 1, 0x10, 0x40),
+			mapper: 0x41, prgRam: 8192,
+		},
+		{
+			// bytes 7-15 are "DiskDude!": the 'k' in byte 9 would mean PAL
+			name:   "DiskDude!",
+			data:   testRomData(16384, 8192, 1, 1, 0x10, 'D', 'i', 's', 'k', 'D', 'u', 'd', 'e', '!'),
+			mapper: 1, prgRam: 8192,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := LoadCartridge(bytes.NewReader(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.IsNes2() {
+				t.Error("detected as NES 2.0")
+			}
+			if c.MapperID() != test.mapper {
+				t.Errorf("mapper %d, want %d", c.MapperID(), test.mapper)
+			}
+			if c.mirrorMode != test.mirror {
+				t.Errorf("mirroring %d, want %d", c.mirrorMode, test.mirror)
+			}
+			if c.battery != test.battery {
+				t.Errorf("battery %v, want %v", c.battery, test.battery)
+			}
+			if c.Timing() != test.timing {
+				t.Errorf("timing %d, want %d", c.Timing(), test.timing)
+			}
+			if c.PrgRamSize() != test.prgRam || c.PrgNvramSize() != test.prgNvram {
+				t.Errorf("PRG RAM %d + %d NVRAM, want %d + %d", c.PrgRamSize(), c.PrgNvramSize(), test.prgRam, test.prgNvram)
+			}
+			if c.ChrRamSize() != test.chrRamSize {
+				t.Errorf("CHR RAM %d, want %d", c.ChrRamSize(), test.chrRamSize)
+			}
+			if c.ConsoleType() != ConsoleNES {
+				t.Errorf("console type %d", c.ConsoleType())
+			}
+		})
+	}
+}
+
+func TestDecodeNes2Header(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		mapper    int
+		submapper int
+		prg, chr  int
+		prgRam    int
+		prgNvram  int
+		chrRam    int
+		chrNvram  int
+		timing    int
+		expansion int
+	}{
+		{
+			name: "plain",
+			data: testRomData(32768, 8192, 2, 1, 0x00, 0x08, 0x00, 0x00, 0x07, 0x00, 0x00),
+			prg:  32768, chr: 8192, prgRam: 8192,
+		},
+		{
+			name:   "mapper MSB, submapper, NVRAM, Dendy, expansion device",
+			data:   testRomData(16384, 0, 1, 0, 0x42, 0x08, 0x31, 0x00, 0x77, 0x77, 0x03, 0x00, 0x00, 0x01),
+			mapper: 0x104, submapper: 3, prg: 16384, chr: 0,
+			prgRam: 8192, prgNvram: 8192, chrRam: 8192, chrNvram: 8192,
+			timing: TimingDendy, expansion: 1,
+		},
+		{
+			name: "size MSBs",
+			data: testRomData(0x200*16384, 0, 0x00, 0x00, 0x00, 0x08, 0x00, 0x02),
+			prg:  0x200 * 16384, chr: 0,
+		},
+		{
+			// EEEEEEMM: 2^13 * 1 = 8 KB PRG, 2^11 * 3 = 6 KB CHR
+			name: "exponent-multiplier sizes",
+			data: testRomData(8192, 6144, 0x34, 0x2D, 0x00, 0x08, 0x00, 0xFF, 0x00, 0x00, 0x02),
+			prg:  8192, chr: 6144, timing: TimingMulti,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := LoadCartridge(bytes.NewReader(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.IsNes2() {
+				t.Error("not detected as NES 2.0")
+			}
+			if c.MapperID() != test.mapper || c.Submapper() != test.submapper {
+				t.Errorf("mapper %d.%d, want %d.%d", c.MapperID(), c.Submapper(), test.mapper, test.submapper)
+			}
+			chr := len(c.chr)
+			if c.chrRam {
+				chr = 0
+			}
+			if len(c.prg) != test.prg || chr != test.chr {
+				t.Errorf("PRG %d, CHR %d, want %d, %d", len(c.prg), chr, test.prg, test.chr)
+			}
+			if c.PrgRamSize() != test.prgRam || c.PrgNvramSize() != test.prgNvram {
+				t.Errorf("PRG RAM %d + %d NVRAM, want %d + %d", c.PrgRamSize(), c.PrgNvramSize(), test.prgRam, test.prgNvram)
+			}
+			if c.ChrRamSize() != test.chrRam || c.ChrNvramSize() != test.chrNvram {
+				t.Errorf("CHR RAM %d + %d NVRAM, want %d + %d", c.ChrRamSize(), c.ChrNvramSize(), test.chrRam, test.chrNvram)
+			}
+			if c.Timing() != test.timing {
+				t.Errorf("timing %d, want %d", c.Timing(), test.timing)
+			}
+			if c.ExpansionDevice() != test.expansion {
+				t.Errorf("expansion device %d, want %d", c.ExpansionDevice(), test.expansion)
+			}
+		})
+	}
+}
+
+func TestNes2RomSize(t *testing.T) {
+	tests := []struct {
+		lsb, msb byte
+		unit     int
+		want     int
+	}{
+		{0x02, 0x0, 16384, 32768},
+		{0x00, 0x1, 8192, 256 * 8192},
+		{0x34, 0xF, 16384, 8192},           // 2^13 * 1
+		{0x2D, 0xF, 8192, 6144},            // 2^11 * 3
+		{0xFF, 0xF, 16384, maxRomSize + 1}, // 2^63 * 7: too big to represent
+	}
+	for _, test := range tests {
+		if got := nes2RomSize(test.lsb, test.msb, test.unit); got != test.want {
+			t.Errorf("nes2RomSize(%#x, %#x, %d) = %d, want %d", test.lsb, test.msb, test.unit, got, test.want)
+		}
+	}
+}
+
+func TestLoadCartridgeErrors(t *testing.T) {
+	var truncated *TruncatedError
+	var unsupported *UnsupportedFeatureError
+	tests := []struct {
+		name  string
+		data  []byte
+		check func(err error) bool
+	}{
+		{"empty", nil, func(err error) bool {
+			return errors.As(err, &truncated) && truncated.Section == "header"
+		}},
+		{"bad magic", append([]byte("NES\x00"), make([]byte, 12)...), func(err error) bool {
+			return errors.Is(err, ErrBadMagic)
+		}},
+		{"truncated trainer", testRomData(100, 0, 1, 1, 0x04), func(err error) bool {
+			return errors.As(err, &truncated) && truncated.Section == "trainer"
+		}},
+		{"truncated PRG ROM", testRomData(16384, 0, 2, 1), func(err error) bool {
+			return errors.As(err, &truncated) && truncated.Section == "PRG ROM" && truncated.Expected == 32768 && truncated.Actual == 16384
+		}},
+		{"truncated CHR ROM", testRomData(16384, 4096, 1, 1), func(err error) bool {
+			return errors.As(err, &truncated) && truncated.Section == "CHR ROM" && truncated.Actual == 4096
+		}},
+		{"no PRG ROM", testRomData(0, 8192, 0, 1), func(err error) bool {
+			return errors.As(err, &unsupported)
+		}},
+		{"Vs. System", testRomData(16384, 8192, 1, 1, 0x00, 0x09), func(err error) bool {
+			return errors.As(err, &unsupported)
+		}},
+		{"miscellaneous ROMs", testRomData(16384, 8192, 1, 1, 0x00, 0x08, 0, 0, 0, 0, 0, 0, 1), func(err error) bool {
+			return errors.As(err, &unsupported)
+		}},
+		{"huge ROM", testRomData(0, 0, 0xFF, 0, 0x00, 0x08, 0, 0x0F), func(err error) bool {
+			return errors.As(err, &unsupported)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := LoadCartridge(bytes.NewReader(test.data))
+			if err == nil {
+				t.Fatalf("loaded %+v", c.header)
+			}
+			if !test.check(err) {
+				t.Errorf("wrong error: %v (%T)", err, err)
+			}
+		})
+	}
+}
+
+func TestUnsupportedMapper(t *testing.T) {
+	c, err := LoadCartridge(bytes.NewReader(testRomData(16384, 8192, 1, 1, 0x50)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewNes(c)
+	var unsupported *UnsupportedMapperError
+	if !errors.As(err, &unsupported) || unsupported.MapperID != 5 {
+		t.Errorf("wrong error: %v (%T)", err, err)
+	}
+}
+
+// Cartridges with less PRG or CHR than a bank have to be mirrored rather than
+// crashing the mappers.
+func TestUndersizedCartridges(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		// 8 KB PRG (2^13 * 1)
+		{"NROM with 8 KB PRG", testRomData(8192, 8192, 0x34, 1, 0x00, 0x08, 0x00, 0x0F)},
+		// 128 bytes of CHR RAM (64 << 1)
+		{"NROM with 128 byte CHR RAM", testRomData(16384, 0, 1, 0, 0x00, 0x08, 0x00, 0x00, 0x00, 0x01)},
+		{"CNROM with 128 byte CHR RAM", testRomData(16384, 0, 1, 0, 0x30, 0x08, 0x00, 0x00, 0x00, 0x01)},
+		// 2 KB CHR ROM (2^11 * 1)
+		{"MMC1 with 2 KB CHR", testRomData(16384, 2048, 1, 0x2C, 0x10, 0x08, 0x00, 0xF0)},
+		{"MMC1 with 8 KB PRG", testRomData(8192, 8192, 0x34, 1, 0x10, 0x08, 0x00, 0x0F)},
+		{"MMC3 with 8 KB PRG and 2 KB CHR", testRomData(8192, 2048, 0x34, 0x2C, 0x40, 0x08, 0x00, 0xFF)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := LoadCartridge(bytes.NewReader(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			console, err := NewNes(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// switch to every bank, reading everything each time
+			for _, bank := range []byte{0x00, 0x01, 0x07, 0x1F, 0xFF} {
+				for addr := 0x8000; addr <= 0xFFFF; addr += 0x2000 {
+					for i := 0; i < 5; i++ {
+						// MMC1 takes 5 writes; MMC3 has bank select/data pairs
+						console.mapper.Write(address(addr), bank)
+						console.mapper.Write(address(addr+1), bank)
+					}
+				}
+				for addr := 0; addr <= 0x1FFF; addr++ {
+					console.PeekPPU(uint16(addr))
+					console.ppu.mem.Write(address(addr), bank)
+				}
+				for addr := 0x6000; addr <= 0xFFFF; addr++ {
+					console.PeekCPU(uint16(addr))
+				}
+			}
+			console.EmulateFrame()
+		})
+	}
+}
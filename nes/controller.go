@@ -0,0 +1,67 @@
+package nes
+
+const (
+	ButtonA = iota
+	ButtonB
+	ButtonSelect
+	ButtonStart
+	ButtonUp
+	ButtonDown
+	ButtonLeft
+	ButtonRight
+)
+
+type Controller struct {
+	nes  *Nes
+	port int
+
+	buttons [8]bool // latched from the Input
+	index   int
+	strobe  byte
+}
+
+func NewController(nes *Nes, port int) *Controller {
+	return &Controller{
+		nes:  nes,
+		port: port,
+	}
+}
+
+func (c *Controller) latch() {
+	if c.nes.input != nil {
+		c.buttons = c.nes.input.Buttons(c.port)
+	}
+}
+
+func (c *Controller) Read() byte {
+	if c.strobe&1 == 1 {
+		// while strobe is high, the shift register keeps reloading
+		c.latch()
+	}
+	var data byte = 0
+	if c.index < 8 && c.buttons[c.index] {
+		data |= 1
+	}
+	if c.strobe&1 == 1 {
+		c.index = 0
+	} else {
+		c.index++
+	}
+	// only the low bits are driven; the rest are open bus (usually $40, from the address high byte)
+	// https://wiki.nesdev.com/w/index.php/Standard_controller#Output_($4016/$4017_read)
+	return data | c.nes.cpu.openBus&0xE0
+}
+
+func (c *Controller) Write(data byte) {
+	c.strobe = data
+	if c.strobe&1 == 1 {
+		c.latch()
+		c.index = 0
+	}
+}
+
+// button state is live input, so only the shift register side is saved
+func (c *Controller) Serialize(s *StateStream) {
+	s.Int(&c.index)
+	s.Byte(&c.strobe)
+}
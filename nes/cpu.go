@@ -0,0 +1,544 @@
+package nes
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The CPU is emulated a cycle at a time: every cycle is exactly one bus access
+// (dummy reads and writes included), and the rest of the console is ticked
+// before each one, so register accesses happen on the right PPU dot / APU cycle.
+// http://nesdev.com/6502_cpu.txt
+
+type Cpu struct {
+	nes *Nes
+	mem Memory
+
+	A  byte    // Accumulator
+	X  byte    // X index
+	Y  byte    // Y index
+	PC address // program counter
+	SP byte    // stack pointer
+
+	status_C bool // "Carry"
+	status_Z bool // "Zero"
+	status_I bool // Interrupt enable/disable
+	status_D bool // BCD enable/disable
+	status_V bool // Overflow
+	status_N bool // negative
+
+	totalCycles uint64
+
+	// DMA waiting for the CPU's next read cycle (see runDma)
+	dmcDma      bool
+	dmcDmaDelay int // halt and dummy cycles the DMC fetch still has to wait for
+	oamDma      bool
+	oamDmaPage  byte
+
+	// the last value on the data bus, which is what reads of unmapped addresses return
+	// https://wiki.nesdev.com/w/index.php/Open_bus_behavior
+	openBus byte
+
+	// interrupts
+	irqLine    int  // one bit per source currently asserting IRQ
+	nmiPending bool // NMI is edge triggered, so it's latched until serviced
+
+	// interrupts are polled at the end of every cycle, but acted on at the end of
+	// an instruction, based on the poll during its second to last cycle
+	// https://wiki.nesdev.com/w/index.php/CPU_interrupts#Detailed_interrupt_behavior
+	nmiPolled     bool
+	irqPolled     bool
+	prevNmiPolled bool
+	prevIrqPolled bool
+
+	// set when the CPU has locked up, until reset
+	halted *CpuHaltedError
+
+	// the last few instructions, for diagnostics
+	recent      [cpuRecentLength]cpuRecentEntry
+	recentIndex int
+}
+
+const cpuRecentLength = 16
+
+type cpuRecentEntry struct {
+	pc             address
+	opcode         byte
+	a, x, y, p, sp byte
+	cycle          uint64
+}
+
+// CpuHaltedError describes why and where the CPU stopped executing instructions.
+// The rest of the console keeps running, and a reset recovers.
+type CpuHaltedError struct {
+	Reason string // e.g. "JAM"
+	PC     uint16 // address of the opcode
+	Opcode byte
+	A      byte
+	X      byte
+	Y      byte
+	P      byte
+	SP     byte
+	Cycle  uint64
+	Recent []string // the instructions leading up to it, oldest first
+}
+
+func (e *CpuHaltedError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CPU halted (%s) at $%.4X opcode $%.2X: A:%.2X X:%.2X Y:%.2X P:%.2X SP:%.2X CYC:%d",
+		e.Reason, e.PC, e.Opcode, e.A, e.X, e.Y, e.P, e.SP, e.Cycle)
+	if len(e.Recent) > 0 {
+		b.WriteString("\nrecent instructions:")
+		for _, line := range e.Recent {
+			b.WriteString("\n  " + line)
+		}
+	}
+	return b.String()
+}
+
+func NewCpu(nes *Nes) *Cpu {
+	return &Cpu{
+		nes:      nes,
+		mem:      &CPUMemory{nes: nes},
+		A:        0,
+		X:        0,
+		Y:        0,
+		SP:       0x00, // the reset sequence takes it to 0xFD
+		status_I: true,
+	}
+}
+
+// sources sharing the IRQ line (which is level triggered, and asserted while any of them are)
+const (
+	irqSourceFrameCounter = 1 << iota
+	irqSourceDMC
+	irqSourceMapper
+)
+
+// interrupt vectors
+const (
+	vectorNMI   = 0xFFFA
+	vectorReset = 0xFFFC
+	vectorIRQ   = 0xFFFE // shared with BRK
+)
+
+/* ***** BUS ***** */
+
+// every cycle goes through here: the rest of the console catches up, then the CPU accesses the bus
+func (cpu *Cpu) read(addr address) byte {
+	if cpu.dmcDma || cpu.oamDma {
+		cpu.runDma(addr)
+	}
+	return cpu.busRead(addr)
+}
+
+func (cpu *Cpu) busRead(addr address) byte {
+	cpu.startCycle()
+	data := cpu.mem.Read(addr)
+	cpu.endCycle()
+	return data
+}
+
+func (cpu *Cpu) write(addr address, data byte) {
+	cpu.startCycle()
+	cpu.mem.Write(addr, data)
+	cpu.endCycle()
+}
+
+// a cycle where the CPU doesn't touch the bus
+func (cpu *Cpu) stall() {
+	cpu.startCycle()
+	cpu.endCycle()
+}
+
+func (cpu *Cpu) startCycle() {
+	cpu.totalCycles++
+	cpu.nes.tick()
+}
+
+func (cpu *Cpu) endCycle() {
+	cpu.prevNmiPolled, cpu.prevIrqPolled = cpu.nmiPolled, cpu.irqPolled
+	cpu.nmiPolled = cpu.nmiPending
+	cpu.irqPolled = cpu.irqLine != 0 && !cpu.status_I
+}
+
+// read the byte at PC, and advance it
+func (cpu *Cpu) fetch() byte {
+	data := cpu.read(cpu.PC)
+	cpu.PC++
+	return data
+}
+
+func (cpu *Cpu) fetch16() address {
+	low := cpu.fetch()
+	high := cpu.fetch()
+	return address(high)<<8 | address(low)
+}
+
+// read a pointer that doesn't carry into the high byte (zero page and JMP indirect)
+func (cpu *Cpu) readPointer(addr address) address {
+	low := cpu.read(addr)
+	high := cpu.read((addr & 0xFF00) | address(byte(addr)+1))
+	return address(high)<<8 | address(low)
+}
+
+func (cpu *Cpu) stackPush(data byte) {
+	cpu.write(address(0x0100+uint16(cpu.SP)), data)
+	cpu.SP--
+}
+
+func (cpu *Cpu) stackPull() byte {
+	cpu.SP++
+	return cpu.read(address(0x0100 + uint16(cpu.SP)))
+}
+
+// the stack read before a pull, while the stack pointer is incremented
+func (cpu *Cpu) stackPeek() {
+	cpu.read(address(0x0100 + uint16(cpu.SP)))
+}
+
+func (cpu *Cpu) statusPack(status_B bool) (data byte) {
+	if cpu.status_C {
+		data |= 1 << 0
+	}
+	if cpu.status_Z {
+		data |= 1 << 1
+	}
+	if cpu.status_I {
+		data |= 1 << 2
+	}
+	if cpu.status_D {
+		data |= 1 << 3
+	}
+	if status_B {
+		data |= 1 << 4
+	}
+	data |= 1 << 5
+	if cpu.status_V {
+		data |= 1 << 6
+	}
+	if cpu.status_N {
+		data |= 1 << 7
+	}
+	return
+}
+
+func (cpu *Cpu) statusUnpack(data byte) {
+	cpu.status_C = data&(1<<0) > 0
+	cpu.status_Z = data&(1<<1) > 0
+	cpu.status_I = data&(1<<2) > 0
+	cpu.status_D = data&(1<<3) > 0
+	cpu.status_V = data&(1<<6) > 0
+	cpu.status_N = data&(1<<7) > 0
+}
+
+func (cpu *Cpu) setZN(value byte) {
+	cpu.status_Z = value == 0
+	cpu.status_N = value&0x80 != 0
+}
+
+/* ***** ADDRESSING ***** */
+
+// compute the operand's effective address, with the dummy reads each mode does along the way.
+// Indexed modes read from the address before the carry into the high byte is fixed up: only
+// when it's needed for reads, but always for writes (which can't take back a wrong write).
+func (cpu *Cpu) operandAddress(mode int, write bool) address {
+	switch mode {
+	case modeZeroPage:
+		return address(cpu.fetch())
+	case modeZeroPageX, modeZeroPageY:
+		base := cpu.fetch()
+		cpu.read(address(base))
+		if mode == modeZeroPageX {
+			return address(base + cpu.X)
+		}
+		return address(base + cpu.Y)
+	case modeAbsolute:
+		return cpu.fetch16()
+	case modeAbsoluteX, modeAbsoluteY:
+		base := cpu.fetch16()
+		index := cpu.X
+		if mode == modeAbsoluteY {
+			index = cpu.Y
+		}
+		return cpu.indexAddress(base, index, write)
+	case modeIndirectX:
+		pointer := cpu.fetch()
+		cpu.read(address(pointer))
+		return cpu.readPointer(address(pointer + cpu.X))
+	case modeIndirectY:
+		base := cpu.readPointer(address(cpu.fetch()))
+		return cpu.indexAddress(base, cpu.Y, write)
+	}
+	panic(fmt.Sprintf("no operand address for addressing mode %d", mode))
+}
+
+func (cpu *Cpu) indexAddress(base address, index byte, write bool) address {
+	addr := base + address(index)
+	if write || addr&0xFF00 != base&0xFF00 {
+		cpu.read((base & 0xFF00) | (addr & 0x00FF))
+	}
+	return addr
+}
+
+/* ***** EXECUTION ***** */
+
+// emulate for at least `cycles` cycles -- returns number of cycles actually emulated for
+func (cpu *Cpu) Emulate(cycles int) int {
+	start := cpu.totalCycles
+	for cpu.totalCycles-start < uint64(cycles) {
+		cpu.step()
+	}
+	return int(cpu.totalCycles - start)
+}
+
+// run a single instruction or interrupt
+func (cpu *Cpu) step() {
+	if cpu.halted != nil {
+		// stuck, ignoring interrupts, while the rest of the console (and DMA) runs on
+		if cpu.dmcDma || cpu.oamDma {
+			cpu.runDma(cpu.PC)
+		}
+		cpu.stall()
+		return
+	}
+
+	if cpu.prevNmiPolled || cpu.prevIrqPolled {
+		cpu.read(cpu.PC)
+		cpu.read(cpu.PC)
+		cpu.interrupt(false)
+		return
+	}
+
+	if cpu.nes.trace != nil || cpu.nes.traceCompare != nil {
+		cpu.nes.traceInstruction()
+	}
+
+	recent := cpuRecentEntry{cpu.PC, 0, cpu.A, cpu.X, cpu.Y, cpu.statusPack(false), cpu.SP, cpu.totalCycles}
+	opcode := cpu.fetch()
+	recent.opcode = opcode
+	cpu.recent[cpu.recentIndex] = recent
+	cpu.recentIndex = (cpu.recentIndex + 1) % cpuRecentLength
+
+	instruction := &cpuInstructions[opcode]
+	if instruction.op == nil {
+		// the JAM opcodes lock up the CPU, which then only responds to reset
+		// https://wiki.nesdev.com/w/index.php/CPU_unofficial_opcodes
+		cpu.halt(opcodeTable[opcode].name, cpu.PC-1, opcode)
+		return
+	}
+	cpu.execute(instruction)
+}
+
+func (cpu *Cpu) halt(reason string, pc address, opcode byte) {
+	e := &CpuHaltedError{
+		Reason: reason,
+		PC:     uint16(pc),
+		Opcode: opcode,
+		A:      cpu.A,
+		X:      cpu.X,
+		Y:      cpu.Y,
+		P:      cpu.statusPack(false),
+		SP:     cpu.SP,
+		Cycle:  cpu.totalCycles,
+	}
+	for i := 0; i < cpuRecentLength; i++ {
+		r := cpu.recent[(cpu.recentIndex+i)%cpuRecentLength]
+		if r.cycle == 0 {
+			continue // not filled yet
+		}
+		e.Recent = append(e.Recent, fmt.Sprintf("%.4X  %.2X %-4s A:%.2X X:%.2X Y:%.2X P:%.2X SP:%.2X CYC:%d",
+			r.pc, r.opcode, opcodeTable[r.opcode].name, r.a, r.x, r.y, r.p, r.sp, r.cycle))
+	}
+	cpu.halted = e
+}
+
+func (cpu *Cpu) execute(instruction *cpuInstruction) {
+	op := instruction.op
+	if op.special != nil {
+		op.special(cpu, instruction.mode)
+		return
+	}
+
+	switch instruction.mode {
+	case modeImplied:
+		cpu.read(cpu.PC) // dummy read of the next byte
+		op.implied(cpu)
+		return
+	case modeAccumulator:
+		cpu.read(cpu.PC)
+		cpu.A = op.modify(cpu, cpu.A)
+		return
+	case modeImmediate:
+		op.read(cpu, cpu.fetch())
+		return
+	}
+
+	addr := cpu.operandAddress(instruction.mode, op.read == nil)
+	switch {
+	case op.read != nil:
+		op.read(cpu, cpu.read(addr))
+	case op.write != nil:
+		cpu.write(addr, op.write(cpu))
+	case op.modify != nil:
+		data := cpu.read(addr)
+		cpu.write(addr, data) // the unmodified value is written back first
+		cpu.write(addr, op.modify(cpu, data))
+	}
+}
+
+// push the return address and status, and jump through the interrupt vector. The two
+// cycles before (fetching an opcode for BRK) are done by the caller.
+// An NMI that's pending by the time the vector is chosen hijacks an IRQ or BRK.
+func (cpu *Cpu) interrupt(brk bool) {
+	cpu.stackPush(byte(cpu.PC >> 8))
+	cpu.stackPush(byte(cpu.PC))
+
+	vector := address(vectorIRQ)
+	if cpu.nmiPending {
+		cpu.nmiPending = false
+		vector = vectorNMI
+	}
+	cpu.stackPush(cpu.statusPack(brk))
+	cpu.status_I = true
+	cpu.PC = cpu.readPointer(vector)
+
+	// an interrupt isn't polled for again until after the handler's first instruction
+	cpu.clearPolls()
+}
+
+// the reset line keeps the CPU from writing while it runs through the
+// interrupt sequence, so the stack pointer is decremented with nothing pushed
+// https://wiki.nesdev.com/w/index.php/CPU_power_up_state#After_reset
+func (cpu *Cpu) reset() {
+	cpu.read(cpu.PC)
+	cpu.read(cpu.PC)
+	for i := 0; i < 3; i++ {
+		cpu.stackPeek()
+		cpu.SP--
+	}
+	cpu.status_I = true
+	cpu.nmiPending = false
+	cpu.dmcDma, cpu.oamDma = false, false
+	cpu.halted = nil
+	cpu.PC = cpu.readPointer(vectorReset)
+	cpu.clearPolls()
+}
+
+/* ***** DMA ***** */
+
+// ask for a DMC sample fetch
+func (cpu *Cpu) startDmcDma() {
+	if !cpu.dmcDma {
+		cpu.dmcDma = true
+		cpu.dmcDmaDelay = 2
+	}
+}
+
+// ask for a copy of page $xx00-$xxFF to OAM
+func (cpu *Cpu) startOamDma(page byte) {
+	cpu.oamDma = true
+	cpu.oamDmaPage = page
+}
+
+// DMA halts the CPU on a read cycle, so it waits for any writes in progress. The
+// CPU then keeps repeating that read while DMC and OAM DMA take turns on the bus,
+// each reading on "get" cycles; OAM DMA writes on the "put" cycles in between.
+// A DMC fetch on its own takes 3-4 cycles (halt, dummy, alignment, get), and OAM
+// DMA 513-514 (halt, alignment, 256 gets and puts). A DMC fetch in the middle of
+// OAM DMA usually takes 2 cycles, since OAM DMA cycles count as its halt and dummy.
+// https://wiki.nesdev.com/w/index.php/DMA
+func (cpu *Cpu) runDma(addr address) {
+	oamCycles := 0
+	var oamData byte
+	for first := true; cpu.dmcDma || cpu.oamDma; first = false {
+		get := cpu.totalCycles%2 == 1
+		dmcReady := cpu.dmcDma && cpu.dmcDmaDelay == 0
+		if cpu.dmcDmaDelay > 0 {
+			cpu.dmcDmaDelay--
+		}
+		switch {
+		case first:
+			cpu.busRead(addr)
+		case get && dmcReady:
+			data := cpu.busRead(cpu.nes.apu.dmc.currentAddress)
+			cpu.dmcDma = false
+			cpu.nes.apu.dmc.loadSample(data)
+		case get && cpu.oamDma && oamCycles%2 == 0:
+			oamData = cpu.busRead(address(cpu.oamDmaPage)<<8 | address(oamCycles/2))
+			oamCycles++
+		case !get && cpu.oamDma && oamCycles%2 == 1:
+			cpu.write(0x2004, oamData)
+			oamCycles++
+			if oamCycles == 512 {
+				cpu.oamDma = false
+			}
+		case addr == 0x4016 || addr == 0x4017:
+			// the controllers are only clocked by the first of consecutive reads
+			cpu.stall()
+		default:
+			cpu.busRead(addr)
+		}
+	}
+}
+
+func (cpu *Cpu) clearPolls() {
+	cpu.nmiPolled, cpu.irqPolled = false, false
+	cpu.prevNmiPolled, cpu.prevIrqPolled = false, false
+}
+
+func (cpu *Cpu) triggerInterruptNMI() {
+	cpu.nmiPending = true
+}
+
+// assert or release the IRQ line on behalf of `source`
+func (cpu *Cpu) setIRQ(source int, asserted bool) {
+	if asserted {
+		cpu.irqLine |= source
+	} else {
+		cpu.irqLine &^= source
+	}
+}
+
+func (cpu *Cpu) Serialize(s *StateStream) {
+	s.Byte(&cpu.A)
+	s.Byte(&cpu.X)
+	s.Byte(&cpu.Y)
+	s.Address(&cpu.PC)
+	s.Byte(&cpu.SP)
+	s.Bool(&cpu.status_C)
+	s.Bool(&cpu.status_Z)
+	s.Bool(&cpu.status_I)
+	s.Bool(&cpu.status_D)
+	s.Bool(&cpu.status_V)
+	s.Bool(&cpu.status_N)
+	s.Uint64(&cpu.totalCycles)
+	s.Bool(&cpu.dmcDma)
+	s.Int(&cpu.dmcDmaDelay)
+	s.Bool(&cpu.oamDma)
+	s.Byte(&cpu.oamDmaPage)
+	s.Int(&cpu.irqLine)
+	s.Bool(&cpu.nmiPending)
+	s.Bool(&cpu.nmiPolled)
+	s.Bool(&cpu.irqPolled)
+	s.Bool(&cpu.prevNmiPolled)
+	s.Bool(&cpu.prevIrqPolled)
+	s.Byte(&cpu.openBus)
+
+	halted := cpu.halted != nil
+	var haltedPC address
+	var haltedOpcode byte
+	if halted {
+		haltedPC, haltedOpcode = address(cpu.halted.PC), cpu.halted.Opcode
+	}
+	s.Bool(&halted)
+	s.Address(&haltedPC)
+	s.Byte(&haltedOpcode)
+	if s.loading {
+		cpu.halted = nil
+		cpu.recent = [cpuRecentLength]cpuRecentEntry{} // from before the load
+		if halted {
+			cpu.halt(opcodeTable[haltedOpcode].name, haltedPC, haltedOpcode)
+		}
+	}
+}
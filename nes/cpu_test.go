@@ -0,0 +1,244 @@
+package nes
+
+import "testing"
+
+// plays a one byte DMC sample at the highest rate, after `delay`, then runs NOPs
+func dmcTestCode(delay ...byte) []byte {
+	code := append([]byte(nil), delay...)
+	code = append(code,
+		0xA9, 0x0F, // LDA #$0F
+		0x8D, 0x10, 0x40, // STA $4010
+		0xA9, 0x00, // LDA #$00
+		0x8D, 0x12, 0x40, // STA $4012
+		0x8D, 0x13, 0x40, // STA $4013
+		0xA9, 0x10, // LDA #$10
+		0x8D, 0x15, 0x40, // STA $4015
+	)
+	for i := 0; i < 32; i++ {
+		code = append(code, 0xEA) // NOP
+	}
+	return code
+}
+
+func TestDmcDma(t *testing.T) {
+	// the fetch takes 3 or 4 cycles, depending on whether it starts on a get cycle
+	stalls := make(map[int]bool)
+	for _, delay := range [][]byte{nil, {0xA5, 0x00}} { // LDA $00, 3 cycles
+		console := newTestNes(t, dmcTestCode(delay...)...)
+		for console.cpu.PC < 0x8000+address(len(delay))+18 {
+			console.Emulate()
+		}
+		stalled := 0
+		for i := 0; i < 32; i++ {
+			if cycles := console.Emulate(); cycles != 2 {
+				stalls[cycles-2] = true
+				stalled++
+			}
+		}
+		if stalled != 1 {
+			t.Errorf("delay %X: stalled %d times, want once", delay, stalled)
+		}
+		if console.apu.dmc.bytesRemaining != 0 {
+			t.Errorf("delay %X: sample wasn't fetched", delay)
+		}
+	}
+	if len(stalls) != 2 || !stalls[3] || !stalls[4] {
+		t.Errorf("stalled for %v cycles, want 3 and 4", stalls)
+	}
+}
+
+func TestDmcDmaWhileHalted(t *testing.T) {
+	code := dmcTestCode()
+	code[18] = 0x02 // JAM instead of the NOPs
+	console := newTestNes(t, code...)
+	console.EmulateFrame()
+	if console.Halted() == nil {
+		t.Fatal("CPU didn't halt")
+	}
+	if console.apu.dmc.bytesRemaining != 0 {
+		t.Error("sample wasn't fetched")
+	}
+}
+
+// copies $0200-$02FF to OAM from $04, after `delay`, then runs NOPs
+func oamDmaTestCode(delay ...byte) []byte {
+	code := append([]byte(nil), delay...)
+	return append(code,
+		0xA9, 0x04, // LDA #$04
+		0x8D, 0x03, 0x20, // STA $2003
+		0xA9, 0x02, // LDA #$02
+		0x8D, 0x14, 0x40, // STA $4014
+		0xEA, // NOP
+		0xEA, // NOP
+	)
+}
+
+func TestOamDma(t *testing.T) {
+	// 513 or 514 cycles, depending on whether it starts on a get cycle
+	stalls := make(map[int]bool)
+	for _, delay := range [][]byte{nil, {0xA5, 0x00}} { // LDA $00, 3 cycles
+		console := newTestNes(t, oamDmaTestCode(delay...)...)
+		for i := 0; i < 256; i++ {
+			console.ram[0x200+i] = byte(i) ^ 0x5A
+		}
+		for console.cpu.PC < 0x8000+address(len(delay))+10 {
+			console.Emulate()
+		}
+		stalls[console.Emulate()-2] = true
+		if cycles := console.Emulate(); cycles != 2 {
+			t.Errorf("delay %X: next instruction took %d cycles", delay, cycles)
+		}
+		for i := 0; i < 256; i++ {
+			if got, want := console.ppu.oam[(4+i)&0xFF], byte(i)^0x5A; got != want {
+				t.Fatalf("delay %X: OAM $%.2X is $%.2X, want $%.2X", delay, (4+i)&0xFF, got, want)
+			}
+		}
+	}
+	if len(stalls) != 2 || !stalls[513] || !stalls[514] {
+		t.Errorf("stalled for %v cycles, want 513 and 514", stalls)
+	}
+}
+
+func TestDmcDuringOamDma(t *testing.T) {
+	// a 17 byte sample, with OAM DMA at $8200
+	code := dmcTestCode()
+	code[6] = 0x01
+	code = append(code, make([]byte, 0x200-len(code))...)
+	code = append(code, oamDmaTestCode()...)
+	console := newTestNes(t, code...)
+	for i := 0; i < 256; i++ {
+		console.ram[0x200+i] = byte(i)
+	}
+
+	for console.cpu.PC < 0x8012 {
+		console.Emulate()
+	}
+	// start OAM DMA shortly before the sample buffer empties
+	for console.apu.dmc.bitsRemaining != 1 {
+		console.cpu.PC = 0x8012 // NOPs
+		console.Emulate()
+	}
+	console.cpu.PC = 0x8200
+	for console.cpu.PC < 0x820A {
+		console.Emulate()
+	}
+	remaining := console.apu.dmc.bytesRemaining
+	stall := console.Emulate() - 2
+	fetches := int(remaining - console.apu.dmc.bytesRemaining)
+	if fetches == 0 {
+		t.Fatal("sample wasn't fetched during OAM DMA")
+	}
+	// each DMC fetch replaces an OAM DMA read, and OAM DMA has to realign
+	if oam := stall - 2*fetches; oam != 513 && oam != 514 {
+		t.Errorf("stalled for %d cycles with %d DMC fetches", stall, fetches)
+	}
+	for i := 0; i < 256; i++ {
+		if got := console.ppu.oam[(4+i)&0xFF]; got != byte(i) {
+			t.Fatalf("OAM $%.2X is $%.2X, want $%.2X", (4+i)&0xFF, got, i)
+		}
+	}
+}
+
+// testIrq asserts the IRQ line, as the mapper, from CPU cycle `from` until released
+type testIrq struct {
+	cpu      *Cpu
+	from     uint64
+	released bool
+}
+
+func (i *testIrq) IRQ() bool {
+	return !i.released && i.cpu.totalCycles >= i.from
+}
+
+// irqAfter asserts IRQ `cycles` cycles from now
+func irqAfter(console *Nes, cycles uint64) *testIrq {
+	irq := &testIrq{cpu: console.cpu, from: console.cpu.totalCycles + cycles}
+	console.irqMapper = irq
+	return irq
+}
+
+// runToIrq runs until the IRQ handler at $8100, and returns the address it will return to
+func runToIrq(t *testing.T, console *Nes) address {
+	t.Helper()
+	for i := 0; console.cpu.PC != 0x8100; i++ {
+		if i == 100 {
+			t.Fatal("IRQ wasn't taken")
+		}
+		console.Emulate()
+	}
+	sp := 0x100 + address(console.cpu.SP)
+	return address(console.ram[sp+3])<<8 | address(console.ram[sp+2])
+}
+
+func TestIrqFlagLatency(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   []byte
+		masked bool   // I flag to start with
+		from   uint64 // cycle the IRQ is asserted on
+		want   address
+	}{
+		// CLI and PLP clear I after the poll, so the IRQ waits for the next instruction
+		{"CLI", []byte{0x58, 0xEA, 0xEA, 0xEA}, true, 1, 0x8002},
+		{"PLP clearing I", []byte{0xA9, 0x00, 0x48, 0x28, 0xEA, 0xEA, 0xEA}, true, 1, 0x8005},
+		// and SEI and PLP set it after the poll, so one more IRQ gets through
+		{"SEI", []byte{0x78, 0xEA, 0xEA}, false, 1, 0x8001},
+		{"PLP setting I", []byte{0xA9, 0x04, 0x48, 0x28, 0xEA, 0xEA}, false, 6, 0x8004},
+		// RTI restores I before the poll
+		{"RTI", []byte{0xA9, 0x80, 0x48, 0xA9, 0x0A, 0x48, 0xA9, 0x00, 0x48, 0x40, 0xEA, 0xEA, 0xEA}, true, 1, 0x800A},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			console := newTestNes(t, test.code...)
+			console.cpu.status_I = test.masked
+			irqAfter(console, test.from)
+			if got := runToIrq(t, console); got != test.want {
+				t.Errorf("IRQ returns to $%.4X, want $%.4X", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSharedIrqLine(t *testing.T) {
+	console := newTestNes(t,
+		0x58,             // CLI
+		0x4C, 0x01, 0x80, // JMP $8001
+	)
+	// both are asserted while masked: neither is lost
+	irq := irqAfter(console, 0)
+	console.apu.frameIrqFlag = true
+	runToIrq(t, console)
+
+	// the line stays asserted until both sources are acknowledged
+	irq.released = true
+	console.Emulate() // RTI
+	if console.Emulate(); console.cpu.PC != 0x8100 {
+		t.Fatal("IRQ wasn't taken again for the frame counter")
+	}
+	console.apu.frameIrqFlag = false
+	console.Emulate() // RTI
+	for i := 0; i < 20; i++ {
+		if console.Emulate(); console.cpu.PC == 0x8100 {
+			t.Fatal("IRQ was taken after every source was acknowledged")
+		}
+	}
+}
+
+func TestBranchIrqDelay(t *testing.T) {
+	code := []byte{
+		0xA2, 0x01, // LDX #$01
+		0x58,       // CLI
+		0xD0, 0x00, // BNE $8005, on cycles 5 to 7
+		0xEA, // NOP
+		0xEA, // NOP
+	}
+	// a taken branch doesn't poll on its last cycle, so an IRQ that first shows
+	// up in the poll on its second cycle waits for the next instruction
+	for from, want := range map[uint64]address{5: 0x8005, 6: 0x8006, 7: 0x8006} {
+		console := newTestNes(t, code...)
+		irqAfter(console, from)
+		if got := runToIrq(t, console); got != want {
+			t.Errorf("IRQ from cycle %d returns to $%.4X, want $%.4X", from, got, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package nes
+
+import (
+	"fmt"
+	"strings"
+)
+
+// disassemble the instruction at `pc` in nestest.log style, showing the memory it
+// accesses as it is now (so this should be called before it executes).
+// Returns the instruction bytes, and the disassembly marked with * if it's unofficial.
+func (nes *Nes) disassemble(pc address) (string, string) {
+	peek := func(addr address) byte { return nes.PeekCPU(uint16(addr)) }
+	peek16 := func(addr address) address { return address(peek(addr)) | address(peek(addr+1))<<8 }
+	// page wrapping, as with JMP ($xxFF) and zero page pointers
+	peek16Wrapped := func(addr address) address {
+		return address(peek(addr)) | address(peek(addr&0xFF00|(addr+1)&0x00FF))<<8
+	}
+
+	op := opcodeTable[peek(pc)]
+	size := modeSizes[op.mode]
+	raw := make([]string, size)
+	for i := range raw {
+		raw[i] = fmt.Sprintf("%.2X", peek(pc+address(i)))
+	}
+	arg8 := peek(pc + 1)
+	arg16 := peek16(pc + 1)
+
+	var operand string
+	switch op.mode {
+	case modeAccumulator:
+		operand = "A"
+	case modeImmediate:
+		operand = fmt.Sprintf("#$%.2X", arg8)
+	case modeZeroPage:
+		operand = fmt.Sprintf("$%.2X = %.2X", arg8, peek(address(arg8)))
+	case modeZeroPageX, modeZeroPageY:
+		index, reg := nes.cpu.X, "X"
+		if op.mode == modeZeroPageY {
+			index, reg = nes.cpu.Y, "Y"
+		}
+		addr := address(arg8 + index)
+		operand = fmt.Sprintf("$%.2X,%s @ %.2X = %.2X", arg8, reg, addr, peek(addr))
+	case modeRelative:
+		operand = fmt.Sprintf("$%.4X", pc+2+address(int8(arg8)))
+	case modeAbsolute:
+		if op.name == "JMP" || op.name == "JSR" {
+			operand = fmt.Sprintf("$%.4X", arg16)
+		} else {
+			operand = fmt.Sprintf("$%.4X = %.2X", arg16, peek(arg16))
+		}
+	case modeAbsoluteX, modeAbsoluteY:
+		index, reg := nes.cpu.X, "X"
+		if op.mode == modeAbsoluteY {
+			index, reg = nes.cpu.Y, "Y"
+		}
+		addr := arg16 + address(index)
+		operand = fmt.Sprintf("$%.4X,%s @ %.4X = %.2X", arg16, reg, addr, peek(addr))
+	case modeIndirect:
+		operand = fmt.Sprintf("($%.4X) = %.4X", arg16, peek16Wrapped(arg16))
+	case modeIndirectX:
+		pointer := address(arg8 + nes.cpu.X)
+		addr := peek16Wrapped(pointer)
+		operand = fmt.Sprintf("($%.2X,X) @ %.2X = %.4X = %.2X", arg8, pointer, addr, peek(addr))
+	case modeIndirectY:
+		base := peek16Wrapped(address(arg8))
+		addr := base + address(nes.cpu.Y)
+		operand = fmt.Sprintf("($%.2X),Y = %.4X @ %.4X = %.2X", arg8, base, addr, peek(addr))
+	}
+
+	text := op.name
+	if operand != "" {
+		text += " " + operand
+	}
+	if op.unofficial {
+		text = "*" + text
+	} else {
+		text = " " + text
+	}
+	return strings.Join(raw, " "), text
+}
@@ -0,0 +1,34 @@
+package nes
+
+// Color is a pixel in 0x00RRGGBB format.
+type Color uint32
+
+// Video receives the picture as the PPU renders it.
+type Video interface {
+	// PushPixel is called for every visible pixel (256x240), as it's drawn.
+	PushPixel(x int, y int, c Color)
+	// PushFrame is called at the start of vertical blank, once the frame is complete.
+	PushFrame()
+}
+
+// RawVideo can also be implemented by a Video, to get the PPU's output before it's
+// turned into colors (e.g. for an NtscFilter).
+type RawVideo interface {
+	// PushRawPixel is called along with PushPixel, with the 9 bit value the PPU
+	// output (an index into a Palette), and the phase (0-11) of the NTSC color
+	// subcarrier at the start of the pixel.
+	PushRawPixel(x int, y int, pixel uint16, phase int)
+}
+
+// Audio receives the mixed output of the APU.
+type Audio interface {
+	// PushSample is called once per sample, at the rate set with SetAudioSampleRate.
+	PushSample(sample float32)
+}
+
+// Input provides the state of the controllers.
+type Input interface {
+	// Buttons returns which buttons (indexed by ButtonA, ButtonB...) are held
+	// on the controller in port 0 or 1. It's polled whenever the game latches the controllers.
+	Buttons(port int) [8]bool
+}
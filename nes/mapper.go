@@ -0,0 +1,99 @@
+package nes
+
+type Mapper interface {
+	Read(addr address) byte
+	Write(addr address, data byte)
+
+	// save state section: banking registers, PRG RAM, etc.
+	Serialize(s *StateStream)
+}
+
+// ResetMapper is implemented by mappers with state that's reset along with the console.
+type ResetMapper interface {
+	Reset()
+}
+
+// PeekMapper is implemented by mappers that react to the PPU's reads (e.g. MMC3
+// watching A12), to read without doing so.
+type PeekMapper interface {
+	Peek(addr address) byte
+}
+
+// prgRam is the RAM at $6000-$7FFF, sized by the cartridge header. It's mirrored
+// if it's smaller than 8 KB, and reads are open bus if there's none.
+type prgRam []byte
+
+func newPrgRam(cartridge *Cartridge) prgRam {
+	return make(prgRam, cartridge.prgRamSize+cartridge.prgNvramSize)
+}
+
+func (r prgRam) read(nes *Nes, addr address) byte {
+	if len(r) == 0 {
+		return nes.cpu.openBus
+	}
+	return r[int(addr-0x6000)%len(r)]
+}
+
+func (r prgRam) write(addr address, data byte) {
+	if len(r) > 0 {
+		r[int(addr-0x6000)%len(r)] = data
+	}
+}
+
+// romIndex finds a byte in PRG or CHR ROM from a bank number and an offset into
+// the bank. Like on hardware, banks past the end of the ROM mirror the ones before
+// (and a ROM smaller than one bank mirrors itself). Negative banks count from the end.
+func romIndex(rom []byte, bankSize int, bank int, offset int) int {
+	banks := len(rom) / bankSize
+	if banks == 0 {
+		return offset % len(rom)
+	}
+	bank %= banks
+	if bank < 0 {
+		bank += banks
+	}
+	return bank*bankSize + offset
+}
+
+// Mappers that can assert the CPU's IRQ line (e.g. MMC3) implement this as well
+type InterruptMapper interface {
+	IRQ() bool
+}
+
+func NewMapper(nes *Nes) (Mapper, error) {
+	switch nes.cartridge.mapperID {
+	case 0:
+		return NewMapperMMC0(nes), nil
+	case 1:
+		return NewMapperMMC1(nes), nil
+	case 3:
+		return NewMapper3(nes), nil
+	case 4:
+		return NewMapperMMC3(nes), nil
+	default:
+		return nil, &UnsupportedMapperError{MapperID: nes.cartridge.mapperID, Submapper: nes.cartridge.submapper}
+	}
+}
+
+const (
+	MirrorHorizontal = 0
+	MirrorVertical   = 1
+	MirrorSingleA    = 2
+	MirrorSingleB    = 3
+	MirrorFour       = 4
+)
+
+var MirrorLookup = [][4]int{
+	{0, 0, 1, 1},
+	{0, 1, 0, 1},
+	{0, 0, 0, 0},
+	{1, 1, 1, 1},
+	{0, 1, 2, 3},
+}
+
+// from nametable address in range 0x2000 to 0x2FFF to VRAM (0x0000 to 0x1000)
+func TranslateVRamAddress(addr address, mirrorMode int) int {
+	addr -= 0x2000
+	bank := MirrorLookup[mirrorMode][addr/(0x400)]
+	return (bank * 0x400) + int(addr%0x400)
+}
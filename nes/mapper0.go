@@ -0,0 +1,51 @@
+package nes
+
+type MapperMMC0 struct {
+	nes    *Nes
+	prgRam prgRam // not on most NROM boards, but Family Basic and test ROMs use it
+}
+
+func NewMapperMMC0(nes *Nes) *MapperMMC0 {
+	return &MapperMMC0{
+		nes:    nes,
+		prgRam: newPrgRam(nes.cartridge),
+	}
+}
+
+func (m *MapperMMC0) Read(addr address) byte {
+	switch {
+	case addr <= 0x1FFF:
+		return m.nes.cartridge.chr[romIndex(m.nes.cartridge.chr, 0x2000, 0, int(addr))]
+	case addr <= 0x2FFF:
+		return m.nes.ppu.vram[TranslateVRamAddress(addr, m.nes.cartridge.mirrorMode)]
+	case addr >= 0x6000 && addr <= 0x7FFF:
+		return m.prgRam.read(m.nes, addr)
+	case addr >= 0x8000:
+		// 16 KB ROMs are mirrored at $C000
+		return m.nes.cartridge.prg[romIndex(m.nes.cartridge.prg, 0x4000, int(addr-0x8000)/0x4000, int(addr&0x3FFF))]
+	default:
+		//panic(fmt.Sprintf("MMC read out of bounds: %.4X", addr))
+	}
+	return m.nes.cpu.openBus
+}
+
+func (m *MapperMMC0) Write(addr address, data byte) {
+	switch {
+	case addr <= 0x1FFF:
+		m.nes.cartridge.chr[romIndex(m.nes.cartridge.chr, 0x2000, 0, int(addr))] = data // if RAM
+	case addr <= 0x2FFF:
+		m.nes.ppu.vram[TranslateVRamAddress(addr, m.nes.cartridge.mirrorMode)] = data
+	case addr >= 0x6000 && addr <= 0x7FFF:
+		m.prgRam.write(addr, data)
+	default:
+		// panic(fmt.Sprintf("MMC write out of bounds: %.4X", addr))
+	}
+}
+
+func (m *MapperMMC0) Serialize(s *StateStream) {
+	s.Bytes(m.prgRam)
+}
+
+func (m *MapperMMC0) PrgRam() []byte {
+	return m.prgRam
+}
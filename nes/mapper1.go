@@ -1,4 +1,4 @@
-package main
+package nes
 
 type MapperMMC1 struct {
 	nes *Nes
@@ -13,16 +13,28 @@ type MapperMMC1 struct {
 	registerCHR1    byte
 	registerPRG     byte
 
-	prgRam [8192]byte
+	prgRam prgRam
 }
 
 func NewMapperMMC1(nes *Nes) *MapperMMC1 {
 	return &MapperMMC1{
 		nes:             nes,
+		prgRam:          newPrgRam(nes.cartridge),
 		registerControl: 0x0f,
 	}
 }
 
+// clears the shift register and switches to PRG mode 3 (fixed last bank at $C000)
+func (m *MapperMMC1) reset() {
+	m.shiftNumber = 0
+	m.shiftRegister = 0
+	m.registerControl |= 0x0C
+}
+
+func (m *MapperMMC1) Reset() {
+	m.reset()
+}
+
 func (m *MapperMMC1) Read(addr address) byte {
 	switch {
 	case addr <= 0x0FFF:
@@ -35,42 +47,41 @@ func (m *MapperMMC1) Read(addr address) byte {
 		// mirroring
 		return m.nes.ppu.vram[TranslateVRamAddress(addr, m.mirrorMode)]
 	case addr < 0x6000:
-		// ?????
-		return 0
+		// not connected: open bus
+		return m.nes.cpu.openBus
 	case addr >= 0x6000 && addr <= 0x7FFF:
 		// internal ram
-		return m.prgRam[addr-0x6000]
+		return m.prgRam.read(m.nes, addr)
 	case addr <= 0xBFFF:
 		// PRG bank 1
+		var bank int
 		switch (m.registerControl & 0xC) >> 2 {
-		case 0:
-			fallthrough
-		case 1:
+		case 0, 1:
 			// switch 32 KB at $8000, ignoring low bit of bank number
-			return m.nes.cartridge.prg[16384*int(m.registerPRG&0xFE)+int(addr-0x8000)]
+			bank = int(m.registerPRG & 0xFE)
 		case 2:
 			// fix first bank at $8000
-			return m.nes.cartridge.prg[int(addr-0x8000)]
+			bank = 0
 		case 3:
 			// switch 16 KB bank at $8000
-			return m.nes.cartridge.prg[16384*int(m.registerPRG)+int(addr-0x8000)]
+			bank = int(m.registerPRG)
 		}
-	case addr <= 0xFFFF:
+		return m.nes.cartridge.prg[romIndex(m.nes.cartridge.prg, 16384, bank, int(addr-0x8000))]
+	default:
 		// PRG bank 2
+		var bank int
 		switch (m.registerControl & 0xC) >> 2 {
-		case 0:
-			fallthrough
-		case 1:
-			return m.nes.cartridge.prg[16384*int(m.registerPRG|0x1)+int(addr-0xC000)]
+		case 0, 1:
+			bank = int(m.registerPRG | 0x1)
 		case 2:
 			// switch 16 KB bank at $C000
-			return m.nes.cartridge.prg[16384*int(m.registerPRG)+int(addr-0xC000)]
+			bank = int(m.registerPRG)
 		case 3:
 			// fix last bank at $C000
-			return m.nes.cartridge.prg[len(m.nes.cartridge.prg)-16384+int(addr-0xC000)]
+			bank = -1
 		}
+		return m.nes.cartridge.prg[romIndex(m.nes.cartridge.prg, 16384, bank, int(addr-0xC000))]
 	}
-	return 0
 }
 
 func (m *MapperMMC1) getCHR1Index(addr address) int {
@@ -79,8 +90,7 @@ func (m *MapperMMC1) getCHR1Index(addr address) int {
 		// 8KB mode
 		bank &= 0xFE
 	}
-	bank %= len(m.nes.cartridge.chr) / 4096 // XXX is this correct behavior?
-	return int(addr-0x0000) + (bank * 4096)
+	return romIndex(m.nes.cartridge.chr, 4096, bank, int(addr-0x0000))
 }
 
 func (m *MapperMMC1) getCHR2Index(addr address) int {
@@ -91,8 +101,7 @@ func (m *MapperMMC1) getCHR2Index(addr address) int {
 	} else {
 		bank = int(m.registerCHR1)
 	}
-	bank %= len(m.nes.cartridge.chr) / 4096 // XXX is this correct behavior?
-	return int(addr-0x1000) + (bank * 4096)
+	return romIndex(m.nes.cartridge.chr, 4096, bank, int(addr-0x1000))
 }
 
 func (m *MapperMMC1) Write(addr address, data byte) {
@@ -106,14 +115,12 @@ func (m *MapperMMC1) Write(addr address, data byte) {
 		}
 	} else if addr <= 0x7FFF {
 		if m.registerPRG&0x10 == 0 {
-			m.prgRam[addr-0x6000] = data
+			m.prgRam.write(addr, data)
 		}
 	} else {
 		// TODO ignore writes on consecutive cycles
 		if data&0x80 > 0 {
-			// clear shift register
-			m.shiftNumber = 0
-			m.shiftRegister = 0
+			m.reset()
 		} else {
 			// add to shift register
 			m.shiftRegister = m.shiftRegister | ((data & 0x1) << uint(m.shiftNumber))
@@ -147,3 +154,18 @@ func (m *MapperMMC1) Write(addr address, data byte) {
 		}
 	}
 }
+
+func (m *MapperMMC1) Serialize(s *StateStream) {
+	s.Byte(&m.shiftRegister)
+	s.Int(&m.shiftNumber)
+	s.Int(&m.mirrorMode)
+	s.Byte(&m.registerControl)
+	s.Byte(&m.registerCHR0)
+	s.Byte(&m.registerCHR1)
+	s.Byte(&m.registerPRG)
+	s.Bytes(m.prgRam)
+}
+
+func (m *MapperMMC1) PrgRam() []byte {
+	return m.prgRam
+}
@@ -0,0 +1,38 @@
+package nes
+
+type Mapper3 struct {
+	nes  *Nes
+	bank int // CHR bank, mirrored if it's past the end
+}
+
+func NewMapper3(nes *Nes) *Mapper3 {
+	return &Mapper3{
+		nes:  nes,
+		bank: -1, // last bank
+	}
+}
+
+func (m *Mapper3) Read(addr address) byte {
+	switch {
+	case addr <= 0x1FFF:
+		return m.nes.cartridge.chr[romIndex(m.nes.cartridge.chr, 0x2000, m.bank, int(addr))]
+	case addr <= 0x2FFF:
+		return m.nes.ppu.vram[TranslateVRamAddress(addr, m.nes.cartridge.mirrorMode)]
+	case addr >= 0x8000:
+		// 16 KB ROMs are mirrored at $C000
+		return m.nes.cartridge.prg[romIndex(m.nes.cartridge.prg, 0x4000, int(addr-0x8000)/0x4000, int(addr&0x3FFF))]
+	default:
+		//panic(fmt.Sprintf("MMC read out of bounds: %.4X", addr))
+	}
+	return m.nes.cpu.openBus
+}
+
+func (m *Mapper3) Write(addr address, data byte) {
+	if addr >= 0x8000 && addr <= 0xFFFF {
+		m.bank = int(data)
+	}
+}
+
+func (m *Mapper3) Serialize(s *StateStream) {
+	s.Int(&m.bank)
+}
@@ -1,4 +1,4 @@
-package main
+package nes
 
 type MapperMMC3 struct {
 	nes *Nes
@@ -6,6 +6,12 @@ type MapperMMC3 struct {
 	irqEnabled bool
 	irqLatch   byte
 	irqReload  bool
+	irqCounter byte
+	irqPending bool
+
+	// PPU A12 edge detection
+	a12High     bool
+	a12LowSince uint64
 
 	mirrorMode int // 0: vertical, 1: horizontal
 
@@ -14,37 +20,46 @@ type MapperMMC3 struct {
 	bankPRGMode        int
 	bankCHRMode        int
 
-	prgRam [8192]byte
+	prgRam prgRam
 }
 
 func NewMapperMMC3(nes *Nes) *MapperMMC3 {
 	return &MapperMMC3{
 		nes:        nes,
-		irqEnabled: true,
+		prgRam:     newPrgRam(nes.cartridge),
+		irqEnabled: false,
 		irqReload:  false,
 	}
 }
 
 func (m *MapperMMC3) Read(addr address) byte {
+	if addr <= 0x1FFF {
+		m.watchA12(addr)
+	}
+	return m.Peek(addr)
+}
+
+func (m *MapperMMC3) Peek(addr address) byte {
 	switch {
 	case addr <= 0x1FFF:
 		return m.nes.cartridge.chr[m.resolvePpuRomAddr(addr)]
 	case addr <= 0x2FFF:
 		return m.nes.ppu.vram[TranslateVRamAddress(addr, 1-m.mirrorMode)]
 	case addr < 0x6000:
-		// ?????
+		// not connected: open bus
 	case addr <= 0x7FFF:
 		// internal ram
-		return m.prgRam[addr-0x6000]
+		return m.prgRam.read(m.nes, addr)
 	case addr <= 0xFFFF:
 		return m.nes.cartridge.prg[m.resolveCpuRomAddr(addr)]
 	}
-	return 0
+	return m.nes.cpu.openBus
 }
 
 func (m *MapperMMC3) Write(addr address, data byte) {
 	switch {
 	case addr <= 0x1FFF:
+		m.watchA12(addr)
 		m.nes.cartridge.chr[m.resolvePpuRomAddr(addr)] = data
 	case addr <= 0x2FFF:
 		m.nes.ppu.vram[TranslateVRamAddress(addr, 1-m.mirrorMode)] = data
@@ -52,7 +67,7 @@ func (m *MapperMMC3) Write(addr address, data byte) {
 		// ?????
 	case addr <= 0x7FFF:
 		// write to prg ram
-		m.prgRam[addr-0x6000] = data
+		m.prgRam.write(addr, data)
 	case addr <= 0x9FFF && (addr&0x1 == 0):
 		// bank select register
 		m.bankSelectRegister = int(data & 0x7)
@@ -76,17 +91,50 @@ func (m *MapperMMC3) Write(addr address, data byte) {
 		m.irqLatch = data
 	case addr <= 0xDFFF && (addr&0x1 == 1):
 		// IRQ reload register
+		m.irqCounter = 0
 		m.irqReload = true
 	case addr <= 0xFFFF && (addr&0x1 == 0):
-		// IRQ disable register
-		// TODO acknowledge pending interrupts (??)
+		// IRQ disable register (also acknowledges any pending interrupt)
 		m.irqEnabled = false
+		m.irqPending = false
 	case addr <= 0xFFFF && (addr&0x1 == 1):
 		// IRQ enable register
 		m.irqEnabled = true
 	}
 }
 
+// the scanline counter is clocked by rising edges on PPU A12, which are filtered:
+// A12 needs to have been low for a few CPU cycles (M2 falling edges) for a rise to count.
+// https://wiki.nesdev.com/w/index.php/MMC3#IRQ_Specifics
+func (m *MapperMMC3) watchA12(addr address) {
+	high := addr&0x1000 != 0
+	if high && !m.a12High {
+		if m.nes.ppu.cycles-m.a12LowSince >= 3*3 {
+			m.clockIrqCounter()
+		}
+	} else if !high && m.a12High {
+		m.a12LowSince = m.nes.ppu.cycles
+	}
+	m.a12High = high
+}
+
+func (m *MapperMMC3) clockIrqCounter() {
+	if m.irqCounter == 0 || m.irqReload {
+		m.irqCounter = m.irqLatch
+		m.irqReload = false
+	} else {
+		m.irqCounter--
+	}
+	if m.irqCounter == 0 && m.irqEnabled {
+		m.irqPending = true
+	}
+}
+
+// IRQ line, held until acknowledged through $E000
+func (m *MapperMMC3) IRQ() bool {
+	return m.irqPending
+}
+
 func (m *MapperMMC3) resolvePpuRomAddr(addr address) int {
 	bank_addr := addr & 0x3FF
 	bank_index := int(addr&0x1C00) >> 10
@@ -106,7 +154,7 @@ func (m *MapperMMC3) resolvePpuRomAddr(addr address) int {
 		bank = m.bankRegisters[bank_index-2]
 	}
 
-	return bank*1024 + int(bank_addr)
+	return romIndex(m.nes.cartridge.chr, 1024, bank, int(bank_addr))
 }
 
 func (m *MapperMMC3) resolveCpuRomAddr(addr address) int {
@@ -114,26 +162,46 @@ func (m *MapperMMC3) resolveCpuRomAddr(addr address) int {
 	if m.bankPRGMode == 0 {
 		switch {
 		case addr <= 0x9FFF:
-			return (8192 * m.bankRegisters[6]) + int(addr-0x8000)
+			return romIndex(m.nes.cartridge.prg, 8192, m.bankRegisters[6], int(addr-0x8000))
 		case addr <= 0xBFFF:
-			return (8192 * m.bankRegisters[7]) + int(addr-0xA000)
+			return romIndex(m.nes.cartridge.prg, 8192, m.bankRegisters[7], int(addr-0xA000))
 		case addr <= 0xDFFF:
-			return (8192*-2 + len(nes.cartridge.prg)) + int(addr-0xC000)
+			return romIndex(m.nes.cartridge.prg, 8192, -2, int(addr-0xC000))
 		case addr <= 0xFFFF:
-			return (8192*-1 + len(nes.cartridge.prg)) + int(addr-0xE000)
+			return romIndex(m.nes.cartridge.prg, 8192, -1, int(addr-0xE000))
 		}
 	} else {
 		switch {
 		case addr <= 0x9FFF:
-			return (8192*-2 + len(nes.cartridge.prg)) + int(addr-0x8000)
+			return romIndex(m.nes.cartridge.prg, 8192, -2, int(addr-0x8000))
 		case addr <= 0xBFFF:
-			return (8192 * m.bankRegisters[7]) + int(addr-0xA000)
+			return romIndex(m.nes.cartridge.prg, 8192, m.bankRegisters[7], int(addr-0xA000))
 		case addr <= 0xDFFF:
-			return (8192 * m.bankRegisters[6]) + int(addr-0xC000)
+			return romIndex(m.nes.cartridge.prg, 8192, m.bankRegisters[6], int(addr-0xC000))
 		case addr <= 0xFFFF:
-			return (8192*-1 + len(nes.cartridge.prg)) + int(addr-0xE000)
+			return romIndex(m.nes.cartridge.prg, 8192, -1, int(addr-0xE000))
 		}
 	}
 
 	panic("should be unreachable")
 }
+
+func (m *MapperMMC3) Serialize(s *StateStream) {
+	s.Bool(&m.irqEnabled)
+	s.Byte(&m.irqLatch)
+	s.Bool(&m.irqReload)
+	s.Byte(&m.irqCounter)
+	s.Bool(&m.irqPending)
+	s.Bool(&m.a12High)
+	s.Uint64(&m.a12LowSince)
+	s.Int(&m.mirrorMode)
+	s.Ints(m.bankRegisters[:])
+	s.Int(&m.bankSelectRegister)
+	s.Int(&m.bankPRGMode)
+	s.Int(&m.bankCHRMode)
+	s.Bytes(m.prgRam)
+}
+
+func (m *MapperMMC3) PrgRam() []byte {
+	return m.prgRam
+}
@@ -0,0 +1,82 @@
+package nes
+
+import (
+	"bytes"
+	"testing"
+)
+
+// irqScanline renders a frame on MMC3 with the IRQ counter latched to 20, and
+// returns the scanline the IRQ happens on (or -2 if it doesn't)
+func irqScanline(t *testing.T, ctrl byte, oam [256]byte) int {
+	t.Helper()
+	c, err := LoadCartridge(bytes.NewReader(testRomData(32768, 8192, 2, 1, 0x40)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	console, err := NewNes(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppu, mapper := console.ppu, console.mapper.(*MapperMMC3)
+	ppu.setControl(ctrl)
+	ppu.oam = oam
+	ppu.flag_renderBackground, ppu.flag_renderSprites = 1, 1
+	for ppu.scanlineCounter != -1 {
+		ppu.Emulate(1)
+	}
+	mapper.Write(0xC000, 20) // latch
+	mapper.Write(0xC001, 0)  // reload
+	mapper.Write(0xE001, 0)  // enable
+	for ppu.scanlineCounter != 241 {
+		ppu.Emulate(1)
+		if mapper.IRQ() {
+			return ppu.scanlineCounter
+		}
+	}
+	return -2
+}
+
+func TestMMC3Irq(t *testing.T) {
+	var noSprites [256]byte
+	for i := range noSprites {
+		noSprites[i] = 0xF0
+	}
+	// 8 sprites using the left pattern table on scanlines 4 to 19
+	tallSprites := noSprites
+	for n := 0; n < 8; n++ {
+		tallSprites[n*4], tallSprites[n*4+1] = 4, 0x00
+	}
+	// and the same, alternating between the left and right pattern tables
+	mixedSprites := tallSprites
+	for n := 1; n < 8; n += 2 {
+		mixedSprites[n*4+1] = 0x01
+	}
+
+	tests := []struct {
+		name     string
+		ctrl     byte // PPUCTRL
+		oam      [256]byte
+		scanline int
+	}{
+		// A12 rises once a scanline, for the sprite fetches. The prerender scanline
+		// reloads the counter, and 20 scanlines later it reaches 0.
+		{"8x8 sprites on the right", 0x08, noSprites, 19},
+		// A12 is low for the sprite fetches, and rises again for the first background
+		// fetch for the next scanline (at dot 321)
+		{"background on the right", 0x10, noSprites, 19},
+		// empty sprite slots fetch tile $FF, which is on the right
+		{"8x16 sprites", 0x20, noSprites, 19},
+		// with all 8 slots taken by sprites on the left, A12 doesn't rise for 16 scanlines
+		{"8x16 sprites on the left", 0x20, tallSprites, 35},
+		// A12 rises for every other sprite, but only the first rise on a scanline comes
+		// after it has been low for long enough to count
+		{"8x16 sprites on both sides", 0x20, mixedSprites, 19},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if scanline := irqScanline(t, test.ctrl, test.oam); scanline != test.scanline {
+				t.Errorf("IRQ on scanline %d, want %d", scanline, test.scanline)
+			}
+		})
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package nes
 
 type Memory interface {
 	Read(addr address) byte
@@ -13,81 +13,85 @@ type CPUMemory struct {
 	nes *Nes
 }
 
-func (*CPUMemory) Read(addr address) byte {
+func (m *CPUMemory) Read(addr address) byte {
 	// see https://wiki.nesdev.com/w/index.php/CPU_memory_map
+	cpu := m.nes.cpu
 	switch {
 	case addr <= 0x1FFF:
-		return nes.ram[addr&0x07FF]
+		cpu.openBus = m.nes.ram[addr&0x07FF]
 	case addr <= 0x3FFF:
-		return nes.ppu.ReadRegister(int(addr & 0x7))
+		cpu.openBus = m.nes.ppu.ReadRegister(int(addr & 0x7))
 	case addr == 0x4016:
-		return nes.controller1.Read()
+		cpu.openBus = m.nes.controller1.Read()
 	case addr == 0x4017:
-		return nes.controller2.Read()
-	case addr <= 0x4017:
-		// TODO NES APU and I/O REGISTERS
+		cpu.openBus = m.nes.controller2.Read()
+	case addr == 0x4015:
+		// $4015 is inside the CPU, so reading it doesn't drive the external data bus
+		return m.nes.apu.ReadRegister(addr)
 	case addr <= 0x401F:
-		// CPU test mode
+		// write-only APU registers and CPU test mode: open bus
 	case addr >= 0x4020:
-		return nes.mapper.Read(addr)
+		cpu.openBus = m.nes.mapper.Read(addr)
 	}
-	return 0
+	return cpu.openBus
 }
 
-func (*CPUMemory) Write(addr address, data byte) {
+func (m *CPUMemory) Write(addr address, data byte) {
 	// fmt.Println("mem write", addr, data)
 
+	m.nes.cpu.openBus = data
 	switch {
 	case addr <= 0x1FFF:
-		nes.ram[addr&0x07FF] = data
+		m.nes.ram[addr&0x07FF] = data
 	case addr <= 0x3FFF:
-		nes.ppu.WriteRegister(int(addr&0x7), data)
+		m.nes.ppu.WriteRegister(int(addr&0x7), data)
 	case addr == 0x4014:
 		// OAMDMA
-		nes.ppu.WriteRegister(0x4014, data)
+		m.nes.ppu.WriteRegister(0x4014, data)
 	case addr == 0x4016:
-		nes.controller1.Write(data)
-		nes.controller2.Write(data)
+		m.nes.controller1.Write(data)
+		m.nes.controller2.Write(data)
+	case addr <= 0x4017:
+		m.nes.apu.WriteRegister(addr, data)
 	case addr >= 0x4020:
-		nes.mapper.Write(addr, data)
+		m.nes.mapper.Write(addr, data)
 	}
-	// TODO do the APU and I/O
 }
 
 type PPUMemory struct {
 	nes *Nes
 }
 
-func (*PPUMemory) Read(addr address) byte {
+func (m *PPUMemory) Read(addr address) byte {
 	// https://wiki.nesdev.com/w/index.php/PPU_memory_map
 	addr = addr & 0x3FFF
 	switch {
 	case addr <= 0x2FFF:
-		return nes.mapper.Read(addr)
+		return m.nes.mapper.Read(addr)
 	case addr <= 0x3EFF:
 		// mirrored from 0x2000
-		return nes.mapper.Read(addr - 0x1000)
+		return m.nes.mapper.Read(addr - 0x1000)
 	case addr <= 0x3FFF:
 		// (only bottom 0x1F -- 5 bits)
 		index := addr & 0x1F
-		return nes.ppu.palette[index]
+		return m.nes.ppu.palette[index]
 	}
 	return 0 // can't be reached
 }
 
-func (*PPUMemory) Write(addr address, data byte) {
+func (m *PPUMemory) Write(addr address, data byte) {
 	addr = addr & 0x3FFF
 	switch {
 	case addr <= 0x2FFF:
-		nes.mapper.Write(addr, data)
+		m.nes.mapper.Write(addr, data)
 	case addr <= 0x3EFF:
 		// mirrored from 0x2000
-		nes.mapper.Write(addr-0x1000, data)
+		m.nes.mapper.Write(addr-0x1000, data)
 	case addr <= 0x3FFF:
 		index := addr & 0x1F
 		if index == 0x10 || index == 0x14 || index == 0x18 || index == 0x1C {
 			index -= 0x10
 		}
-		nes.ppu.palette[index] = data
+		m.nes.ppu.palette[index] = data
 	}
 }
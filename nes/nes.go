@@ -0,0 +1,222 @@
+package nes
+
+import "io"
+
+type address uint16
+
+type Nes struct {
+	cpu         *Cpu
+	ppu         *Ppu
+	apu         *Apu
+	cartridge   *Cartridge
+	mapper      Mapper
+	irqMapper   InterruptMapper // the mapper, if it has an IRQ
+	controller1 *Controller
+	controller2 *Controller
+
+	ram [4096]byte // only 2048 bytes are included in the console normally
+
+	region       Region
+	powerOnState PowerOnState
+	ppuRemainder int // PAL runs 3.2 PPU cycles per CPU cycle; fifths of a PPU cycle left over
+
+	// frontend interfaces (any of them can be nil)
+	video    Video
+	rawVideo RawVideo // the video, if it takes raw pixels too
+	audio    Audio
+	input    Input
+
+	batterySaved []byte // PRG RAM as of the last .sav load or save
+
+	// debugging
+	trace        io.Writer
+	traceCompare *TraceComparator
+}
+
+func NewNes(cartridge *Cartridge) (*Nes, error) {
+	nes := Nes{
+		cartridge: cartridge,
+	}
+	nes.region = RegionForTiming(cartridge.timing)
+	if err := nes.powerOn(); err != nil {
+		return nil, err
+	}
+
+	if nes.HasBattery() {
+		nes.batterySaved = append([]byte(nil), nes.batteryRam()...)
+	}
+
+	return &nes, nil
+}
+
+// create all of the hardware in its power up state. If it fails, nothing is changed.
+func (nes *Nes) powerOn() error {
+	mapper, err := NewMapper(nes)
+	if err != nil {
+		return err
+	}
+	nes.cpu = NewCpu(nes)
+	nes.ppu = NewPpu(nes)
+	nes.apu = NewApu(nes)
+	nes.mapper = mapper
+	nes.irqMapper, _ = nes.mapper.(InterruptMapper)
+	nes.controller1 = NewController(nes, 0)
+	nes.controller2 = NewController(nes, 1)
+	nes.ppuRemainder = 0
+	nes.SetRegion(nes.region)
+	nes.applyPowerOnState()
+
+	// boot up
+	nes.cpu.reset()
+	return nil
+}
+
+// Halted returns a *CpuHaltedError if the CPU has locked up (the PPU and APU keep
+// running, and Reset recovers), or nil.
+func (nes *Nes) Halted() error {
+	if nes.cpu.halted == nil {
+		return nil
+	}
+	return nes.cpu.halted
+}
+
+// Reset presses the console's reset button: the CPU runs its reset sequence, the
+// APU is silenced and the PPU's registers are cleared, but memory is left as it was.
+func (nes *Nes) Reset() {
+	nes.apu.reset()
+	nes.ppu.reset()
+	if m, ok := nes.mapper.(ResetMapper); ok {
+		m.Reset()
+	}
+	nes.cpu.reset()
+}
+
+// PowerCycle turns the console off and on again. Only battery-backed PRG RAM survives.
+// If it fails, the console is left as it was.
+func (nes *Nes) PowerCycle() error {
+	var battery []byte
+	if nes.HasBattery() {
+		battery = append(battery, nes.batteryRam()...)
+	}
+	colors, unlimitedSprites := nes.ppu.colors, nes.ppu.unlimitedSprites
+	sampleRate, sampleRatio := nes.apu.sampleRate, nes.apu.sampleRatio
+
+	if err := nes.powerOn(); err != nil {
+		return err
+	}
+
+	if battery != nil {
+		copy(nes.batteryRam(), battery)
+	}
+	nes.ppu.colors = colors
+	nes.ppu.unlimitedSprites = unlimitedSprites
+	nes.apu.SetSampleRate(sampleRate)
+	nes.apu.SetRateAdjustment(sampleRatio)
+	return nil
+}
+
+func (nes *Nes) SetVideo(video Video) {
+	nes.video = video
+	nes.rawVideo, _ = video.(RawVideo)
+}
+
+func (nes *Nes) SetAudio(audio Audio) {
+	nes.audio = audio
+}
+
+func (nes *Nes) SetInput(input Input) {
+	nes.input = input
+}
+
+// SetAudioSampleRate sets the rate (in Hz) at which samples are pushed to the Audio.
+func (nes *Nes) SetAudioSampleRate(rate float64) {
+	nes.apu.SetSampleRate(rate)
+}
+
+// SetAudioRateAdjustment scales the audio sample rate slightly, for frontends
+// that do dynamic rate control.
+func (nes *Nes) SetAudioRateAdjustment(ratio float64) {
+	nes.apu.SetRateAdjustment(ratio)
+}
+
+func (nes *Nes) Cartridge() *Cartridge {
+	return nes.cartridge
+}
+
+// Frame returns the number of frames emulated so far.
+func (nes *Nes) Frame() int {
+	return nes.ppu.frameCounter
+}
+
+// OAM returns the sprite memory (for debugging views).
+func (nes *Nes) OAM() [256]byte {
+	return nes.ppu.oam
+}
+
+// RAM returns the 2 KB of work RAM.
+func (nes *Nes) RAM() [2048]byte {
+	var ram [2048]byte
+	copy(ram[:], nes.ram[:])
+	return ram
+}
+
+// PeekCPU reads from the CPU's address space without side effects, so
+// registers read as 0 (for debugging views).
+func (nes *Nes) PeekCPU(addr uint16) byte {
+	switch {
+	case addr <= 0x1FFF:
+		return nes.ram[addr&0x07FF]
+	case addr >= 0x4020:
+		return nes.mapper.Read(address(addr))
+	}
+	return 0
+}
+
+// PeekPPU reads from the PPU's address space (for debugging views).
+func (nes *Nes) PeekPPU(addr uint16) byte {
+	if addr&0x3FFF <= 0x1FFF {
+		return nes.ppu.peekPattern(address(addr & 0x3FFF))
+	}
+	return nes.ppu.mem.Read(address(addr))
+}
+
+// SetUnlimitedSprites turns off the limit of 8 sprites per scanline, to get rid of
+// flicker in games that cycle through more. It only changes what's drawn: sprite
+// overflow and sprite 0 hits still happen as they would on hardware.
+func (nes *Nes) SetUnlimitedSprites(unlimited bool) {
+	nes.ppu.unlimitedSprites = unlimited
+}
+
+func (nes *Nes) UnlimitedSprites() bool {
+	return nes.ppu.unlimitedSprites
+}
+
+// Emulate runs one CPU instruction (or interrupt or DMA), returning the number of CPU cycles it took.
+func (nes *Nes) Emulate() int {
+	return nes.cpu.Emulate(1)
+}
+
+// tick runs everything but the CPU for one CPU cycle
+func (nes *Nes) tick() {
+	if nes.region == RegionPAL {
+		nes.ppuRemainder += 16
+		nes.ppu.Emulate(nes.ppuRemainder / 5)
+		nes.ppuRemainder %= 5
+	} else {
+		nes.ppu.Emulate(3)
+	}
+	nes.apu.Emulate(1)
+
+	if nes.irqMapper != nil {
+		nes.cpu.setIRQ(irqSourceMapper, nes.irqMapper.IRQ())
+	}
+}
+
+func (nes *Nes) EmulateFrame() int {
+	cycles := 0
+	startFrame := nes.ppu.frameCounter
+	for startFrame == nes.ppu.frameCounter {
+		cycles += nes.Emulate()
+	}
+	return cycles
+}
@@ -0,0 +1,106 @@
+package nes
+
+import "math"
+
+// NtscFilter turns the PPU's raw output into a picture the way a TV would, from a
+// model of the composite signal: luma and chroma are separated with simple box
+// filters, so sharp edges bleed and leave artifact colors, and since the color
+// subcarrier phase moves from line to line and frame to frame, they crawl.
+// It's only accurate for NTSC consoles.
+// https://wiki.nesdev.com/w/index.php/NTSC_video
+type NtscFilter struct {
+	params NtscParams
+
+	// the signal level of every PPU output value at every subcarrier phase
+	levels [512][12]float32
+	// I and Q demodulation at every phase, with the hue adjustment
+	cos, sin [12]float32
+
+	pixels [240][256]uint16
+	phases [240]int // subcarrier phase at the start of each line
+
+	// per-line running sums of the signal, and of it demodulated
+	sumY, sumI, sumQ [ntscLineSamples + 2*ntscPadding + 1]float32
+
+	output []Color
+}
+
+// NtscWidth is the width of the filtered picture. Each PPU pixel is 8 samples of
+// the signal, which are resampled so the picture has roughly the right aspect ratio
+// when its height is doubled.
+const NtscWidth = 602
+
+const (
+	ntscSamplesPerPixel = 8
+	ntscLineSamples     = 256 * ntscSamplesPerPixel
+	ntscLumaWindow      = 12 // one subcarrier cycle, which cancels out the chroma
+	ntscChromaWindow    = 24 // chroma has less bandwidth than luma
+	ntscPadding         = ntscChromaWindow / 2
+)
+
+func NewNtscFilter(params NtscParams) *NtscFilter {
+	f := &NtscFilter{
+		params: params,
+		output: make([]Color, NtscWidth*240),
+	}
+	for pixel := range f.levels {
+		for phase := range f.levels[pixel] {
+			f.levels[pixel][phase] = float32(ntscSignal(pixel, phase))
+		}
+	}
+	for phase := range f.cos {
+		angle := (float64(phase)*30 + ntscPhaseOffset + params.Hue) * math.Pi / 180
+		f.cos[phase], f.sin[phase] = float32(math.Cos(angle)), float32(math.Sin(angle))
+	}
+	return f
+}
+
+// PushRawPixel records a pixel of the PPU's output (see RawVideo).
+func (f *NtscFilter) PushRawPixel(x int, y int, pixel uint16, phase int) {
+	f.pixels[y][x] = pixel
+	if x == 0 {
+		f.phases[y] = phase
+	}
+}
+
+// Render filters the last pixels pushed, returning a NtscWidth x 240 picture (which
+// is reused by the next call).
+func (f *NtscFilter) Render() []Color {
+	for y := range f.pixels {
+		f.renderLine(y)
+	}
+	return f.output
+}
+
+func (f *NtscFilter) renderLine(y int) {
+	line := &f.pixels[y]
+
+	// the signal, extended past both edges of the picture with the edge pixels
+	var sumY, sumI, sumQ float32
+	for s := -ntscPadding; s < ntscLineSamples+ntscPadding; s++ {
+		x := s / ntscSamplesPerPixel
+		if x < 0 {
+			x = 0
+		} else if x > 255 {
+			x = 255
+		}
+		phase := ((f.phases[y]+s)%12 + 12) % 12
+		signal := f.levels[line[x]][phase]
+		sumY += signal
+		sumI += signal * f.cos[phase]
+		sumQ += signal * f.sin[phase]
+		i := s + ntscPadding + 1
+		f.sumY[i], f.sumI[i], f.sumQ[i] = sumY, sumI, sumQ
+	}
+
+	out := f.output[y*NtscWidth : (y+1)*NtscWidth]
+	for x := range out {
+		// the sample in the middle of this output pixel, as an index into the sums
+		center := (2*x+1)*ntscLineSamples/(2*NtscWidth) + ntscPadding
+		luma := (f.sumY[center+ntscLumaWindow/2] - f.sumY[center-ntscLumaWindow/2]) / ntscLumaWindow
+		// demodulating halves the chroma amplitude, so I and Q are doubled
+		i := 2 * (f.sumI[center+ntscChromaWindow/2] - f.sumI[center-ntscChromaWindow/2]) / ntscChromaWindow
+		q := 2 * (f.sumQ[center+ntscChromaWindow/2] - f.sumQ[center-ntscChromaWindow/2]) / ntscChromaWindow
+		out[x] = ntscColor(ntscDecode(float64(luma), float64(i), float64(q), f.params))
+	}
+}
@@ -0,0 +1,428 @@
+package nes
+
+import "strings"
+
+// addressing modes
+const (
+	modeImplied = iota
+	modeAccumulator
+	modeImmediate
+	modeZeroPage
+	modeZeroPageX
+	modeZeroPageY
+	modeRelative
+	modeAbsolute
+	modeAbsoluteX
+	modeAbsoluteY
+	modeIndirect
+	modeIndirectX
+	modeIndirectY
+)
+
+var modeNames = map[string]int{
+	"imp": modeImplied, "acc": modeAccumulator, "imm": modeImmediate,
+	"zp": modeZeroPage, "zpx": modeZeroPageX, "zpy": modeZeroPageY, "rel": modeRelative,
+	"abs": modeAbsolute, "abx": modeAbsoluteX, "aby": modeAbsoluteY,
+	"ind": modeIndirect, "izx": modeIndirectX, "izy": modeIndirectY,
+}
+
+// instruction length by addressing mode
+var modeSizes = [...]int{1, 1, 2, 2, 2, 2, 2, 3, 3, 3, 3, 2, 2}
+
+type opcodeInfo struct {
+	name       string
+	mode       int
+	unofficial bool
+}
+
+// https://wiki.nesdev.com/w/index.php/CPU_unofficial_opcodes
+// unofficial opcodes are marked with *, and named as in nestest.log where it has them
+// (so ISB is also known as ISC, AXS as SBX, and XAA as ANE)
+var opcodeTable = parseOpcodeTable(`
+	BRK imp  ORA izx *JAM imp *SLO izx *NOP zp   ORA zp   ASL zp  *SLO zp   PHP imp  ORA imm  ASL acc *ANC imm *NOP abs  ORA abs  ASL abs *SLO abs
+	BPL rel  ORA izy *JAM imp *SLO izy *NOP zpx  ORA zpx  ASL zpx *SLO zpx  CLC imp  ORA aby *NOP imp *SLO aby *NOP abx  ORA abx  ASL abx *SLO abx
+	JSR abs  AND izx *JAM imp *RLA izx  BIT zp   AND zp   ROL zp  *RLA zp   PLP imp  AND imm  ROL acc *ANC imm  BIT abs  AND abs  ROL abs *RLA abs
+	BMI rel  AND izy *JAM imp *RLA izy *NOP zpx  AND zpx  ROL zpx *RLA zpx  SEC imp  AND aby *NOP imp *RLA aby *NOP abx  AND abx  ROL abx *RLA abx
+	RTI imp  EOR izx *JAM imp *SRE izx *NOP zp   EOR zp   LSR zp  *SRE zp   PHA imp  EOR imm  LSR acc *ALR imm  JMP abs  EOR abs  LSR abs *SRE abs
+	BVC rel  EOR izy *JAM imp *SRE izy *NOP zpx  EOR zpx  LSR zpx *SRE zpx  CLI imp  EOR aby *NOP imp *SRE aby *NOP abx  EOR abx  LSR abx *SRE abx
+	RTS imp  ADC izx *JAM imp *RRA izx *NOP zp   ADC zp   ROR zp  *RRA zp   PLA imp  ADC imm  ROR acc *ARR imm  JMP ind  ADC abs  ROR abs *RRA abs
+	BVS rel  ADC izy *JAM imp *RRA izy *NOP zpx  ADC zpx  ROR zpx *RRA zpx  SEI imp  ADC aby *NOP imp *RRA aby *NOP abx  ADC abx  ROR abx *RRA abx
+	*NOP imm STA izx *NOP imm *SAX izx  STY zp   STA zp   STX zp  *SAX zp   DEY imp *NOP imm  TXA imp *XAA imm  STY abs  STA abs  STX abs *SAX abs
+	BCC rel  STA izy *JAM imp *AHX izy  STY zpx  STA zpx  STX zpy *SAX zpy  TYA imp  STA aby  TXS imp *TAS aby *SHY abx  STA abx *SHX aby *AHX aby
+	LDY imm  LDA izx  LDX imm *LAX izx  LDY zp   LDA zp   LDX zp  *LAX zp   TAY imp  LDA imm  TAX imp *LXA imm  LDY abs  LDA abs  LDX abs *LAX abs
+	BCS rel  LDA izy *JAM imp *LAX izy  LDY zpx  LDA zpx  LDX zpy *LAX zpy  CLV imp  LDA aby  TSX imp *LAS aby  LDY abx  LDA abx  LDX aby *LAX aby
+	CPY imm  CMP izx *NOP imm *DCP izx  CPY zp   CMP zp   DEC zp  *DCP zp   INY imp  CMP imm  DEX imp *AXS imm  CPY abs  CMP abs  DEC abs *DCP abs
+	BNE rel  CMP izy *JAM imp *DCP izy *NOP zpx  CMP zpx  DEC zpx *DCP zpx  CLD imp  CMP aby *NOP imp *DCP aby *NOP abx  CMP abx  DEC abx *DCP abx
+	CPX imm  SBC izx *NOP imm *ISB izx  CPX zp   SBC zp   INC zp  *ISB zp   INX imp  SBC imm  NOP imp *SBC imm  CPX abs  SBC abs  INC abs *ISB abs
+	BEQ rel  SBC izy *JAM imp *ISB izy *NOP zpx  SBC zpx  INC zpx *ISB zpx  SED imp  SBC aby *NOP imp *ISB aby *NOP abx  SBC abx  INC abx *ISB abx
+`)
+
+func parseOpcodeTable(table string) (opcodes [256]opcodeInfo) {
+	fields := strings.Fields(table)
+	if len(fields) != 256*2 {
+		panic("bad opcode table")
+	}
+	for i := range opcodes {
+		name := fields[i*2]
+		opcodes[i] = opcodeInfo{
+			name:       strings.TrimPrefix(name, "*"),
+			mode:       modeNames[fields[i*2+1]],
+			unofficial: name[0] == '*',
+		}
+	}
+	return
+}
+
+/* ***** OPERATIONS ***** */
+
+// what an instruction does with its operand. The addressing mode decides which of these
+// is used, and which bus accesses are made around it: implied for imp, modify for acc,
+// and otherwise read, write or modify, in that order. special overrides all of them.
+// Usually only one is set, but NOP has both read and implied for its different modes.
+type cpuOp struct {
+	read    func(cpu *Cpu, data byte)      // operand is read
+	write   func(cpu *Cpu) byte            // operand is written
+	modify  func(cpu *Cpu, data byte) byte // operand (or A) is read, then written back
+	implied func(cpu *Cpu)                 // no operand
+	special func(cpu *Cpu, mode int)       // does all of its own bus accesses
+}
+
+type cpuInstruction struct {
+	mode int
+	op   *cpuOp
+}
+
+var cpuInstructions [256]cpuInstruction
+
+func init() {
+	for opcode, info := range opcodeTable {
+		// JAM halts the CPU, leaving no operation
+		if info.name == "JAM" {
+			continue
+		}
+		op, ok := cpuOps[info.name]
+		if !ok {
+			panic("no operation for " + info.name)
+		}
+		cpuInstructions[opcode] = cpuInstruction{mode: info.mode, op: op}
+	}
+}
+
+func (cpu *Cpu) compare(register byte, data byte) {
+	cpu.status_C = register >= data
+	cpu.setZN(register - data)
+}
+
+func (cpu *Cpu) addWithCarry(data byte) {
+	a := cpu.A
+	c := byte(0)
+	if cpu.status_C {
+		c = 1
+	}
+	cpu.A = a + data + c
+	cpu.status_C = int(a)+int(data)+int(c) > 0xFF
+	cpu.status_V = (a^data)&0x80 == 0 && (a^cpu.A)&0x80 != 0
+	cpu.setZN(cpu.A)
+}
+
+func (cpu *Cpu) shiftLeft(data byte) byte {
+	cpu.status_C = data&0x80 != 0
+	data <<= 1
+	cpu.setZN(data)
+	return data
+}
+
+func (cpu *Cpu) shiftRight(data byte) byte {
+	cpu.status_C = data&0x01 != 0
+	data >>= 1
+	cpu.setZN(data)
+	return data
+}
+
+func (cpu *Cpu) rotateLeft(data byte) byte {
+	carry := cpu.status_C
+	cpu.status_C = data&0x80 != 0
+	data <<= 1
+	if carry {
+		data |= 0x01
+	}
+	cpu.setZN(data)
+	return data
+}
+
+func (cpu *Cpu) rotateRight(data byte) byte {
+	carry := cpu.status_C
+	cpu.status_C = data&0x01 != 0
+	data >>= 1
+	if carry {
+		data |= 0x80
+	}
+	cpu.setZN(data)
+	return data
+}
+
+// branch if `condition`: a taken branch takes an extra cycle, and another if it crosses a page
+func (cpu *Cpu) branch(condition bool) {
+	offset := int8(cpu.fetch())
+	if !condition {
+		return
+	}
+	// a taken branch that doesn't cross a page doesn't poll for interrupts on its last
+	// cycle, so ones that just arrived (NMI included) wait for the next instruction
+	// https://wiki.nesdev.com/w/index.php/CPU_interrupts#Branch_instructions_and_interrupts
+	if cpu.irqPolled && !cpu.prevIrqPolled {
+		cpu.irqPolled = false
+	}
+	if cpu.nmiPolled && !cpu.prevNmiPolled {
+		cpu.nmiPolled = false
+	}
+	cpu.read(cpu.PC)
+	destination := cpu.PC + address(offset)
+	if destination&0xFF00 != cpu.PC&0xFF00 {
+		cpu.read((cpu.PC & 0xFF00) | (destination & 0x00FF))
+	}
+	cpu.PC = destination
+}
+
+var cpuOps = map[string]*cpuOp{
+	/* load / store */
+	"LDA": {read: func(cpu *Cpu, data byte) { cpu.A = data; cpu.setZN(data) }},
+	"LDX": {read: func(cpu *Cpu, data byte) { cpu.X = data; cpu.setZN(data) }},
+	"LDY": {read: func(cpu *Cpu, data byte) { cpu.Y = data; cpu.setZN(data) }},
+	"STA": {write: func(cpu *Cpu) byte { return cpu.A }},
+	"STX": {write: func(cpu *Cpu) byte { return cpu.X }},
+	"STY": {write: func(cpu *Cpu) byte { return cpu.Y }},
+
+	/* arithmetic / logic */
+	"ADC": {read: func(cpu *Cpu, data byte) { cpu.addWithCarry(data) }},
+	"SBC": {read: func(cpu *Cpu, data byte) { cpu.addWithCarry(^data) }},
+	"AND": {read: func(cpu *Cpu, data byte) { cpu.A &= data; cpu.setZN(cpu.A) }},
+	"ORA": {read: func(cpu *Cpu, data byte) { cpu.A |= data; cpu.setZN(cpu.A) }},
+	"EOR": {read: func(cpu *Cpu, data byte) { cpu.A ^= data; cpu.setZN(cpu.A) }},
+	"CMP": {read: func(cpu *Cpu, data byte) { cpu.compare(cpu.A, data) }},
+	"CPX": {read: func(cpu *Cpu, data byte) { cpu.compare(cpu.X, data) }},
+	"CPY": {read: func(cpu *Cpu, data byte) { cpu.compare(cpu.Y, data) }},
+	"BIT": {read: func(cpu *Cpu, data byte) {
+		cpu.status_Z = cpu.A&data == 0
+		cpu.status_V = data&0x40 != 0
+		cpu.status_N = data&0x80 != 0
+	}},
+
+	/* read-modify-write */
+	"ASL": {modify: (*Cpu).shiftLeft},
+	"LSR": {modify: (*Cpu).shiftRight},
+	"ROL": {modify: (*Cpu).rotateLeft},
+	"ROR": {modify: (*Cpu).rotateRight},
+	"INC": {modify: func(cpu *Cpu, data byte) byte { data++; cpu.setZN(data); return data }},
+	"DEC": {modify: func(cpu *Cpu, data byte) byte { data--; cpu.setZN(data); return data }},
+
+	/* registers */
+	"INX": {implied: func(cpu *Cpu) { cpu.X++; cpu.setZN(cpu.X) }},
+	"INY": {implied: func(cpu *Cpu) { cpu.Y++; cpu.setZN(cpu.Y) }},
+	"DEX": {implied: func(cpu *Cpu) { cpu.X--; cpu.setZN(cpu.X) }},
+	"DEY": {implied: func(cpu *Cpu) { cpu.Y--; cpu.setZN(cpu.Y) }},
+	"TAX": {implied: func(cpu *Cpu) { cpu.X = cpu.A; cpu.setZN(cpu.X) }},
+	"TAY": {implied: func(cpu *Cpu) { cpu.Y = cpu.A; cpu.setZN(cpu.Y) }},
+	"TXA": {implied: func(cpu *Cpu) { cpu.A = cpu.X; cpu.setZN(cpu.A) }},
+	"TYA": {implied: func(cpu *Cpu) { cpu.A = cpu.Y; cpu.setZN(cpu.A) }},
+	"TSX": {implied: func(cpu *Cpu) { cpu.X = cpu.SP; cpu.setZN(cpu.X) }},
+	"TXS": {implied: func(cpu *Cpu) { cpu.SP = cpu.X }},
+
+	/* flags */
+	"CLC": {implied: func(cpu *Cpu) { cpu.status_C = false }},
+	"SEC": {implied: func(cpu *Cpu) { cpu.status_C = true }},
+	"CLI": {implied: func(cpu *Cpu) { cpu.status_I = false }},
+	"SEI": {implied: func(cpu *Cpu) { cpu.status_I = true }},
+	"CLV": {implied: func(cpu *Cpu) { cpu.status_V = false }},
+	"CLD": {implied: func(cpu *Cpu) { cpu.status_D = false }},
+	"SED": {implied: func(cpu *Cpu) { cpu.status_D = true }},
+
+	// NOP reads its operand, if it has one
+	"NOP": {read: func(cpu *Cpu, data byte) {}, implied: func(cpu *Cpu) {}},
+
+	/* branches */
+	"BPL": {special: func(cpu *Cpu, mode int) { cpu.branch(!cpu.status_N) }},
+	"BMI": {special: func(cpu *Cpu, mode int) { cpu.branch(cpu.status_N) }},
+	"BVC": {special: func(cpu *Cpu, mode int) { cpu.branch(!cpu.status_V) }},
+	"BVS": {special: func(cpu *Cpu, mode int) { cpu.branch(cpu.status_V) }},
+	"BCC": {special: func(cpu *Cpu, mode int) { cpu.branch(!cpu.status_C) }},
+	"BCS": {special: func(cpu *Cpu, mode int) { cpu.branch(cpu.status_C) }},
+	"BNE": {special: func(cpu *Cpu, mode int) { cpu.branch(!cpu.status_Z) }},
+	"BEQ": {special: func(cpu *Cpu, mode int) { cpu.branch(cpu.status_Z) }},
+
+	/* stack */
+	"PHA": {special: func(cpu *Cpu, mode int) {
+		cpu.read(cpu.PC)
+		cpu.stackPush(cpu.A)
+	}},
+	"PHP": {special: func(cpu *Cpu, mode int) {
+		cpu.read(cpu.PC)
+		cpu.stackPush(cpu.statusPack(true))
+	}},
+	"PLA": {special: func(cpu *Cpu, mode int) {
+		cpu.read(cpu.PC)
+		cpu.stackPeek()
+		cpu.A = cpu.stackPull()
+		cpu.setZN(cpu.A)
+	}},
+	"PLP": {special: func(cpu *Cpu, mode int) {
+		cpu.read(cpu.PC)
+		cpu.stackPeek()
+		cpu.statusUnpack(cpu.stackPull())
+	}},
+
+	/* jumps */
+	"JMP": {special: func(cpu *Cpu, mode int) {
+		addr := cpu.fetch16()
+		if mode == modeIndirect {
+			addr = cpu.readPointer(addr)
+		}
+		cpu.PC = addr
+	}},
+	"JSR": {special: func(cpu *Cpu, mode int) {
+		low := cpu.fetch()
+		cpu.stackPeek()
+		cpu.stackPush(byte(cpu.PC >> 8))
+		cpu.stackPush(byte(cpu.PC))
+		high := cpu.read(cpu.PC)
+		cpu.PC = address(high)<<8 | address(low)
+	}},
+	"RTS": {special: func(cpu *Cpu, mode int) {
+		cpu.read(cpu.PC)
+		cpu.stackPeek()
+		low := cpu.stackPull()
+		high := cpu.stackPull()
+		cpu.PC = address(high)<<8 | address(low)
+		cpu.fetch()
+	}},
+	"RTI": {special: func(cpu *Cpu, mode int) {
+		cpu.read(cpu.PC)
+		cpu.stackPeek()
+		cpu.statusUnpack(cpu.stackPull())
+		low := cpu.stackPull()
+		high := cpu.stackPull()
+		cpu.PC = address(high)<<8 | address(low)
+	}},
+	"BRK": {special: func(cpu *Cpu, mode int) {
+		cpu.fetch() // padding byte
+		cpu.interrupt(true)
+	}},
+
+	/* unofficial: read-modify-write combined with an ALU operation */
+	"SLO": {modify: func(cpu *Cpu, data byte) byte {
+		data = cpu.shiftLeft(data)
+		cpu.A |= data
+		cpu.setZN(cpu.A)
+		return data
+	}},
+	"RLA": {modify: func(cpu *Cpu, data byte) byte {
+		data = cpu.rotateLeft(data)
+		cpu.A &= data
+		cpu.setZN(cpu.A)
+		return data
+	}},
+	"SRE": {modify: func(cpu *Cpu, data byte) byte {
+		data = cpu.shiftRight(data)
+		cpu.A ^= data
+		cpu.setZN(cpu.A)
+		return data
+	}},
+	"RRA": {modify: func(cpu *Cpu, data byte) byte {
+		data = cpu.rotateRight(data)
+		cpu.addWithCarry(data)
+		return data
+	}},
+	"DCP": {modify: func(cpu *Cpu, data byte) byte {
+		data--
+		cpu.compare(cpu.A, data)
+		return data
+	}},
+	"ISB": {modify: func(cpu *Cpu, data byte) byte {
+		data++
+		cpu.addWithCarry(^data)
+		return data
+	}},
+
+	/* unofficial: loads and stores of A and X together */
+	"LAX": {read: func(cpu *Cpu, data byte) {
+		cpu.A, cpu.X = data, data
+		cpu.setZN(data)
+	}},
+	"LXA": {read: func(cpu *Cpu, data byte) {
+		data &= cpu.A | unstableMagic
+		cpu.A, cpu.X = data, data
+		cpu.setZN(data)
+	}},
+	"SAX": {write: func(cpu *Cpu) byte { return cpu.A & cpu.X }},
+	"LAS": {read: func(cpu *Cpu, data byte) {
+		data &= cpu.SP
+		cpu.A, cpu.X, cpu.SP = data, data, data
+		cpu.setZN(data)
+	}},
+
+	/* unofficial: immediate */
+	"ANC": {read: func(cpu *Cpu, data byte) {
+		cpu.A &= data
+		cpu.setZN(cpu.A)
+		cpu.status_C = cpu.status_N
+	}},
+	"ALR": {read: func(cpu *Cpu, data byte) {
+		cpu.A = cpu.shiftRight(cpu.A & data)
+	}},
+	"ARR": {read: func(cpu *Cpu, data byte) {
+		cpu.A &= data
+		cpu.A >>= 1
+		if cpu.status_C {
+			cpu.A |= 0x80
+		}
+		cpu.setZN(cpu.A)
+		cpu.status_C = cpu.A&0x40 != 0
+		cpu.status_V = (cpu.A>>6)&1 != (cpu.A>>5)&1
+	}},
+	"AXS": {read: func(cpu *Cpu, data byte) {
+		ax := cpu.A & cpu.X
+		cpu.status_C = ax >= data
+		cpu.X = ax - data
+		cpu.setZN(cpu.X)
+	}},
+	"XAA": {read: func(cpu *Cpu, data byte) {
+		cpu.A = (cpu.A | unstableMagic) & cpu.X & data
+		cpu.setZN(cpu.A)
+	}},
+
+	/* unofficial: stores ANDed with the high byte of the address plus one */
+	"SHY": {special: func(cpu *Cpu, mode int) { cpu.storeHigh(mode, cpu.Y) }},
+	"SHX": {special: func(cpu *Cpu, mode int) { cpu.storeHigh(mode, cpu.X) }},
+	"AHX": {special: func(cpu *Cpu, mode int) { cpu.storeHigh(mode, cpu.A&cpu.X) }},
+	"TAS": {special: func(cpu *Cpu, mode int) {
+		cpu.SP = cpu.A & cpu.X
+		cpu.storeHigh(mode, cpu.SP)
+	}},
+}
+
+// ANDed into A by the unstable XAA and LXA; it varies between chips (and with
+// temperature), but $FF makes them behave as the tests that use them expect
+const unstableMagic = 0xFF
+
+// store `value` & (high byte of the base address + 1). When indexing crosses a page,
+// that value also replaces the high byte of the address written to.
+// https://wiki.nesdev.com/w/index.php/Programming_with_unofficial_opcodes
+func (cpu *Cpu) storeHigh(mode int, value byte) {
+	var base address
+	var index byte
+	switch mode {
+	case modeAbsoluteX:
+		base, index = cpu.fetch16(), cpu.X
+	case modeAbsoluteY:
+		base, index = cpu.fetch16(), cpu.Y
+	case modeIndirectY:
+		base, index = cpu.readPointer(address(cpu.fetch())), cpu.Y
+	}
+	addr := base + address(index)
+	cpu.read((base & 0xFF00) | (addr & 0x00FF))
+	data := value & (byte(base>>8) + 1)
+	if addr&0xFF00 != base&0xFF00 {
+		addr = address(data)<<8 | (addr & 0x00FF)
+	}
+	cpu.write(addr, data)
+}
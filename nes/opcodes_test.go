@@ -0,0 +1,169 @@
+package nes
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// a single instruction at $8000, run from the given registers and zero page
+// (and $0300), checking the registers, memory and cycles afterwards
+type opcodeTest struct {
+	name         string
+	code         []byte
+	a, x, y, p   byte
+	memory       map[address]byte
+	wantA, wantX byte
+	wantY, wantP byte
+	wantMemory   map[address]byte
+	wantCycles   int
+}
+
+var unofficialOpcodeTests = []opcodeTest{
+	{name: "LAX zp", code: []byte{0xA7, 0x10}, p: 0x24, memory: map[address]byte{0x10: 0x80},
+		wantA: 0x80, wantX: 0x80, wantP: 0xA4, wantCycles: 3},
+	{name: "LAX aby", code: []byte{0xBF, 0xF0, 0x02}, y: 0x0F, p: 0x24, memory: map[address]byte{0x2FF: 0x42},
+		wantA: 0x42, wantX: 0x42, wantY: 0x0F, wantP: 0x24, wantCycles: 4},
+	{name: "LAX aby page cross", code: []byte{0xBF, 0xFF, 0x02}, y: 0x01, p: 0x24, memory: map[address]byte{0x300: 0x42},
+		wantA: 0x42, wantX: 0x42, wantY: 0x01, wantP: 0x24, wantCycles: 5},
+	{name: "SAX zp", code: []byte{0x87, 0x10}, a: 0xF0, x: 0x3C, p: 0xA7,
+		wantA: 0xF0, wantX: 0x3C, wantP: 0xA7, wantMemory: map[address]byte{0x10: 0x30}, wantCycles: 3},
+	{name: "DCP zp", code: []byte{0xC7, 0x10}, a: 0x40, p: 0x24, memory: map[address]byte{0x10: 0x41},
+		wantA: 0x40, wantP: 0x27, wantMemory: map[address]byte{0x10: 0x40}, wantCycles: 5},
+	{name: "DCP abx", code: []byte{0xDF, 0x00, 0x03}, a: 0x40, x: 0x01, p: 0x24, memory: map[address]byte{0x301: 0x00},
+		wantA: 0x40, wantX: 0x01, wantP: 0x24, wantMemory: map[address]byte{0x301: 0xFF}, wantCycles: 7},
+	{name: "ISB zp", code: []byte{0xE7, 0x10}, a: 0x20, p: 0x25, memory: map[address]byte{0x10: 0x0F},
+		wantA: 0x10, wantP: 0x25, wantMemory: map[address]byte{0x10: 0x10}, wantCycles: 5},
+	{name: "SLO zp", code: []byte{0x07, 0x10}, a: 0x02, p: 0x24, memory: map[address]byte{0x10: 0x81},
+		wantA: 0x02, wantP: 0x25, wantMemory: map[address]byte{0x10: 0x02}, wantCycles: 5},
+	{name: "RLA zp", code: []byte{0x27, 0x10}, a: 0xFF, p: 0x24, memory: map[address]byte{0x10: 0x81},
+		wantA: 0x02, wantP: 0x25, wantMemory: map[address]byte{0x10: 0x02}, wantCycles: 5},
+	{name: "SRE zp", code: []byte{0x47, 0x10}, a: 0x01, p: 0x24, memory: map[address]byte{0x10: 0x03},
+		wantA: 0x00, wantP: 0x27, wantMemory: map[address]byte{0x10: 0x01}, wantCycles: 5},
+	{name: "RRA zp", code: []byte{0x67, 0x10}, a: 0x10, p: 0x25, memory: map[address]byte{0x10: 0x02},
+		wantA: 0x91, wantP: 0xA4, wantMemory: map[address]byte{0x10: 0x81}, wantCycles: 5},
+	{name: "RRA izy", code: []byte{0x73, 0x20}, a: 0x10, y: 0x01, p: 0x25, memory: map[address]byte{0x20: 0xFF, 0x21: 0x02, 0x300: 0x02},
+		wantA: 0x91, wantY: 0x01, wantP: 0xA4, wantMemory: map[address]byte{0x300: 0x81}, wantCycles: 8},
+	{name: "ANC", code: []byte{0x0B, 0xFF}, a: 0x80, p: 0x24,
+		wantA: 0x80, wantP: 0xA5, wantCycles: 2},
+	{name: "ALR", code: []byte{0x4B, 0x03}, a: 0xFF, p: 0x24,
+		wantA: 0x01, wantP: 0x25, wantCycles: 2},
+	{name: "ARR", code: []byte{0x6B, 0xFF}, a: 0xC0, p: 0x25,
+		wantA: 0xE0, wantP: 0xA5, wantCycles: 2},
+	{name: "ARR overflow", code: []byte{0x6B, 0xFF}, a: 0x80, p: 0x24,
+		wantA: 0x40, wantP: 0x65, wantCycles: 2},
+	{name: "AXS", code: []byte{0xCB, 0x01}, a: 0x0F, x: 0xF3, p: 0x24,
+		wantA: 0x0F, wantX: 0x02, wantP: 0x25, wantCycles: 2},
+	{name: "SBC $EB", code: []byte{0xEB, 0x01}, a: 0x05, p: 0x25,
+		wantA: 0x04, wantP: 0x25, wantCycles: 2},
+	{name: "NOP imm", code: []byte{0x80, 0xFF}, p: 0x24, wantP: 0x24, wantCycles: 2},
+	{name: "NOP zp", code: []byte{0x04, 0x10}, p: 0x24, wantP: 0x24, wantCycles: 3},
+	{name: "NOP abx page cross", code: []byte{0x1C, 0xFF, 0x02}, x: 0x01, p: 0x24,
+		wantX: 0x01, wantP: 0x24, wantCycles: 5},
+	{name: "SHY abx", code: []byte{0x9C, 0x00, 0x03}, x: 0x01, y: 0xFF, p: 0x24,
+		wantX: 0x01, wantY: 0xFF, wantP: 0x24, wantMemory: map[address]byte{0x301: 0x04}, wantCycles: 5},
+	{name: "SHX aby page cross", code: []byte{0x9E, 0xFF, 0x01}, x: 0x05, y: 0x01, p: 0x24, memory: map[address]byte{0x00: 0xAA},
+		wantX: 0x05, wantY: 0x01, wantP: 0x24, wantMemory: map[address]byte{0x00: 0x00, 0x200: 0x00}, wantCycles: 5},
+	{name: "TAS aby", code: []byte{0x9B, 0x00, 0x03}, a: 0xFF, x: 0x0F, y: 0x01, p: 0x24,
+		wantA: 0xFF, wantX: 0x0F, wantY: 0x01, wantP: 0x24, wantMemory: map[address]byte{0x301: 0x04}, wantCycles: 5},
+	{name: "LAS aby", code: []byte{0xBB, 0x00, 0x03}, p: 0x24, memory: map[address]byte{0x300: 0xF0},
+		wantA: 0xF0, wantX: 0xF0, wantP: 0xA4, wantCycles: 4},
+}
+
+func TestUnofficialOpcodes(t *testing.T) {
+	for _, test := range unofficialOpcodeTests {
+		t.Run(test.name, func(t *testing.T) {
+			console := newTestNes(t, test.code...)
+			cpu := console.cpu
+			for i := range console.ram {
+				console.ram[i] = 0
+			}
+			for addr, data := range test.memory {
+				console.ram[addr] = data
+			}
+			cpu.A, cpu.X, cpu.Y = test.a, test.x, test.y
+			cpu.statusUnpack(test.p)
+
+			cycles := console.Emulate()
+			if err := console.Halted(); err != nil {
+				t.Fatal(err)
+			}
+			if cycles != test.wantCycles {
+				t.Errorf("took %d cycles, want %d", cycles, test.wantCycles)
+			}
+			if cpu.PC != 0x8000+address(len(test.code)) {
+				t.Errorf("PC is $%.4X", cpu.PC)
+			}
+			if cpu.A != test.wantA || cpu.X != test.wantX || cpu.Y != test.wantY || cpu.statusPack(false) != test.wantP {
+				t.Errorf("got A:%.2X X:%.2X Y:%.2X P:%.2X, want A:%.2X X:%.2X Y:%.2X P:%.2X",
+					cpu.A, cpu.X, cpu.Y, cpu.statusPack(false), test.wantA, test.wantX, test.wantY, test.wantP)
+			}
+			for addr, want := range test.wantMemory {
+				if got := console.ram[addr]; got != want {
+					t.Errorf("$%.4X is $%.2X, want $%.2X", addr, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTASSetsStackPointer(t *testing.T) {
+	console := newTestNes(t, 0x9B, 0x00, 0x03)
+	console.cpu.A, console.cpu.X = 0xF3, 0x3F
+	console.Emulate()
+	if console.cpu.SP != 0x33 {
+		t.Errorf("SP is $%.2X, want $33", console.cpu.SP)
+	}
+}
+
+func TestJam(t *testing.T) {
+	console := newTestNes(t,
+		0xA9, 0x42, // LDA #$42
+		0xA2, 0x07, // LDX #$07
+		0x02, // JAM
+	)
+	for i := 0; i < 3; i++ {
+		console.Emulate()
+	}
+	var halted *CpuHaltedError
+	if !errors.As(console.Halted(), &halted) {
+		t.Fatal("CPU didn't halt")
+	}
+	if halted.Reason != "JAM" || halted.PC != 0x8004 || halted.Opcode != 0x02 || halted.A != 0x42 || halted.X != 0x07 {
+		t.Errorf("wrong halt: %+v", halted)
+	}
+	if !strings.HasPrefix(halted.Error(), "CPU halted (JAM) at $8004 opcode $02") {
+		t.Errorf("wrong message: %q", halted.Error())
+	}
+	wantRecent := []string{"8000  A9 LDA", "8002  A2 LDX", "8004  02 JAM"}
+	if len(halted.Recent) != len(wantRecent) {
+		t.Fatalf("got recent instructions %q", halted.Recent)
+	}
+	for i, want := range wantRecent {
+		if !strings.HasPrefix(halted.Recent[i], want) {
+			t.Errorf("recent instruction %d is %q, want %q", i, halted.Recent[i], want)
+		}
+	}
+
+	// it stays halted, while the PPU and APU keep running, until reset
+	frame, apuCycles := console.ppu.frameCounter, console.apu.cycles
+	console.EmulateFrame()
+	console.EmulateFrame()
+	if console.Halted() == nil || console.cpu.PC != 0x8005 {
+		t.Error("CPU recovered without a reset")
+	}
+	if console.ppu.frameCounter != frame+2 {
+		t.Errorf("PPU ran %d frames, want 2", console.ppu.frameCounter-frame)
+	}
+	if console.apu.cycles <= apuCycles {
+		t.Error("APU stopped")
+	}
+	console.Reset()
+	if console.Halted() != nil || console.cpu.PC != 0x8000 {
+		t.Error("reset didn't recover the CPU")
+	}
+	console.Emulate()
+	if console.cpu.A != 0x42 || console.cpu.PC != 0x8002 {
+		t.Error("CPU didn't run after reset")
+	}
+}
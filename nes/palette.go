@@ -0,0 +1,196 @@
+package nes
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// https://wiki.nesdev.com/w/index.php/PPU_palettes
+
+// Palette has a color for every 9 bit value the PPU outputs: the emphasis bits
+// (BGR, swapped to BRG on PAL) above a 6 bit palette value.
+type Palette [512]Color
+
+// the colors the 64 palette values are drawn with unless another palette is loaded
+var defaultColors = [64]Color{84*256*256 + 84*256 + 84, 0*256*256 + 30*256 + 116, 8*256*256 + 16*256 + 144, 48*256*256 + 0*256 + 136, 68*256*256 + 0*256 + 100, 92*256*256 + 0*256 + 48, 84*256*256 + 4*256 + 0, 60*256*256 + 24*256 + 0, 32*256*256 + 42*256 + 0, 8*256*256 + 58*256 + 0, 0*256*256 + 64*256 + 0, 0*256*256 + 60*256 + 0, 0*256*256 + 50*256 + 60, 0*256*256 + 0*256 + 0, 0*256*256 + 0*256 + 0, 0*256*256 + 0*256 + 0, 152*256*256 + 150*256 + 152, 8*256*256 + 76*256 + 196, 48*256*256 + 50*256 + 236, 92*256*256 + 30*256 + 228, 136*256*256 + 20*256 + 176, 160*256*256 + 20*256 + 100, 152*256*256 + 34*256 + 32, 120*256*256 + 60*256 + 0, 84*256*256 + 90*256 + 0, 40*256*256 + 114*256 + 0, 8*256*256 + 124*256 + 0, 0*256*256 + 118*256 + 40, 0*256*256 + 102*256 + 120, 0*256*256 + 0*256 + 0, 0*256*256 + 0*256 + 0, 0*256*256 + 0*256 + 0, 236*256*256 + 238*256 + 236, 76*256*256 + 154*256 + 236, 120*256*256 + 124*256 + 236, 176*256*256 + 98*256 + 236, 228*256*256 + 84*256 + 236, 236*256*256 + 88*256 + 180, 236*256*256 + 106*256 + 100, 212*256*256 + 136*256 + 32, 160*256*256 + 170*256 + 0, 116*256*256 + 196*256 + 0, 76*256*256 + 208*256 + 32, 56*256*256 + 204*256 + 108, 56*256*256 + 180*256 + 204, 60*256*256 + 60*256 + 60, 0*256*256 + 0*256 + 0, 0*256*256 + 0*256 + 0, 236*256*256 + 238*256 + 236, 168*256*256 + 204*256 + 236, 188*256*256 + 188*256 + 236, 212*256*256 + 178*256 + 236, 236*256*256 + 174*256 + 236, 236*256*256 + 174*256 + 212, 236*256*256 + 180*256 + 176, 228*256*256 + 196*256 + 144, 204*256*256 + 210*256 + 120, 180*256*256 + 222*256 + 120, 168*256*256 + 226*256 + 144, 152*256*256 + 226*256 + 180, 160*256*256 + 214*256 + 228, 160*256*256 + 162*256 + 160, 0*256*256 + 0*256 + 0, 0*256*256 + 0*256 + 0}
+
+// DefaultPalette returns the built in palette.
+func DefaultPalette() Palette {
+	return EmphasizePalette(defaultColors)
+}
+
+// Each emphasis bit darkens the other two color channels. This is an approximation
+// of what happens to the composite signal, which works for RGB palettes.
+// https://wiki.nesdev.com/w/index.php/NTSC_video#Color_Tint_Bits
+const emphasisAttenuation = 0.816328
+
+// EmphasizePalette extends a 64 color palette with the 7 emphasized versions of it.
+func EmphasizePalette(colors [64]Color) Palette {
+	var full Palette
+	for emphasis := 0; emphasis < 8; emphasis++ {
+		for i, c := range colors {
+			// channels in the same order as the emphasis bits: red, green, blue
+			channels := [3]float64{float64(c >> 16 & 0xFF), float64(c >> 8 & 0xFF), float64(c & 0xFF)}
+			for ch := range channels {
+				if emphasis&^(1<<uint(ch)) != 0 {
+					channels[ch] *= emphasisAttenuation
+				}
+			}
+			full[emphasis<<6|i] = Color(channels[0])<<16 | Color(channels[1])<<8 | Color(channels[2])
+		}
+	}
+	return full
+}
+
+// SetPalette replaces the colors the PPU's output is drawn with.
+func (nes *Nes) SetPalette(palette Palette) {
+	nes.ppu.colors = palette
+}
+
+// Palette returns the colors the PPU's output is drawn with.
+func (nes *Nes) Palette() Palette {
+	return nes.ppu.colors
+}
+
+// LoadPalette reads a .pal file: either 64 RGB triplets (192 bytes), in which case
+// the emphasized colors are derived from them, or all 512 (1536 bytes).
+func LoadPalette(r io.Reader) (Palette, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Palette{}, fmt.Errorf("palette: %v", err)
+	}
+	readColors := func(colors []Color) {
+		for i := range colors {
+			colors[i] = Color(data[i*3])<<16 | Color(data[i*3+1])<<8 | Color(data[i*3+2])
+		}
+	}
+	switch len(data) {
+	case 64 * 3:
+		var colors [64]Color
+		readColors(colors[:])
+		return EmphasizePalette(colors), nil
+	case 512 * 3:
+		var palette Palette
+		readColors(palette[:])
+		return palette, nil
+	}
+	return Palette{}, fmt.Errorf("palette: %d bytes, expected 192 or 1536", len(data))
+}
+
+/* ***** NTSC PALETTE GENERATION ***** */
+
+// NtscParams adjusts how the composite signal is decoded, like the knobs on a TV.
+type NtscParams struct {
+	Hue        float64 // degrees added to the color phase
+	Saturation float64 // 1 is normal
+	Contrast   float64 // 1 is normal
+	Brightness float64 // added to the luma, 0 is normal
+	Gamma      float64 // of the display; the signal is assumed to be made for 2.2
+}
+
+var DefaultNtscParams = NtscParams{
+	Hue:        0,
+	Saturation: 1,
+	Contrast:   1,
+	Brightness: 0,
+	Gamma:      2.2,
+}
+
+// Voltage levels of the PPU's video output, relative to sync.
+// https://wiki.nesdev.com/w/index.php/NTSC_video#Brightness_Levels
+var ntscLevels = [8]float64{
+	0.350, 0.518, 0.962, 1.550, // low part of the square wave, per luma
+	1.094, 1.506, 1.962, 1.962, // high
+}
+
+const (
+	ntscBlack       = 0.518
+	ntscWhite       = 1.962
+	ntscAttenuation = 0.746 // the composite signal is scaled by this while an emphasized color's phase is output
+)
+
+// ntscSignal returns the signal level for a 9 bit PPU output value, at one of the
+// 12 phases of the color subcarrier, normalized so black is 0 and white is 1.
+func ntscSignal(pixel int, phase int) float64 {
+	color := pixel & 0x0F
+	level := (pixel >> 4) & 0x03
+	emphasis := pixel >> 6
+	if color > 13 {
+		// columns $E and $F are black
+		level = 1
+	}
+	inPhase := func(color int) bool {
+		return (color+phase)%12 < 6
+	}
+
+	low, high := ntscLevels[level], ntscLevels[4+level]
+	if color == 0 {
+		low = high
+	}
+	if color > 12 {
+		high = low
+	}
+	signal := low
+	if inPhase(color) {
+		signal = high
+	}
+	// each emphasis bit attenuates the part of the wave in phase with one of the colors
+	if (emphasis&1 != 0 && inPhase(0)) || (emphasis&2 != 0 && inPhase(4)) || (emphasis&4 != 0 && inPhase(8)) {
+		signal *= ntscAttenuation
+	}
+	return (signal - ntscBlack) / (ntscWhite - ntscBlack)
+}
+
+// ntscDecode converts one color subcarrier cycle of signal (in YIQ) to RGB, each 0 to 1.
+func ntscDecode(y, i, q float64, params NtscParams) (r, g, b float64) {
+	y = y*params.Contrast + params.Brightness
+	i, q = i*params.Saturation*params.Contrast, q*params.Saturation*params.Contrast
+
+	// https://en.wikipedia.org/wiki/YIQ
+	r = y + 0.946882*i + 0.623557*q
+	g = y - 0.274788*i - 0.635691*q
+	b = y - 1.108545*i + 1.709007*q
+	return ntscGamma(r, params), ntscGamma(g, params), ntscGamma(b, params)
+}
+
+func ntscGamma(v float64, params NtscParams) float64 {
+	if v <= 0 {
+		return 0
+	}
+	if params.Gamma != 2.2 {
+		v = math.Pow(v, 2.2/params.Gamma)
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+// ntscColor packs 0 to 1 RGB values into a Color.
+func ntscColor(r, g, b float64) Color {
+	return Color(r*255+0.5)<<16 | Color(g*255+0.5)<<8 | Color(b*255+0.5)
+}
+
+// where the I and Q axes are relative to the PPU's phase 0, in degrees, so that the
+// decoded hues line up with a TV locked to the color burst
+const ntscPhaseOffset = 117
+
+// GenerateNtscPalette decodes every PPU output value the way an NTSC TV would,
+// averaging over a full cycle of the color subcarrier.
+// https://wiki.nesdev.com/w/index.php/NTSC_video#Converting_to_RGB
+func GenerateNtscPalette(params NtscParams) Palette {
+	var palette Palette
+	for pixel := range palette {
+		var y, i, q float64
+		for phase := 0; phase < 12; phase++ {
+			signal := ntscSignal(pixel, phase)
+			angle := (float64(phase)*30 + ntscPhaseOffset + params.Hue) * math.Pi / 180
+			y += signal
+			i += signal * math.Cos(angle)
+			q += signal * math.Sin(angle)
+		}
+		palette[pixel] = ntscColor(ntscDecode(y/12, i/6, q/6, params))
+	}
+	return palette
+}
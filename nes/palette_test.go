@@ -0,0 +1,80 @@
+package nes
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoadPalette(t *testing.T) {
+	// 64 colors: the base palette, emphasized
+	var data []byte
+	var colors [64]Color
+	for i := range colors {
+		r, g, b := byte(i*4), byte(255-i*4), byte(i)
+		data = append(data, r, g, b)
+		colors[i] = Color(r)<<16 | Color(g)<<8 | Color(b)
+	}
+	palette, err := LoadPalette(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if palette != EmphasizePalette(colors) {
+		t.Error("192 byte palette wasn't emphasized from its colors")
+	}
+	if palette[0x21] != colors[0x21] {
+		t.Errorf("color $21 is %.6X, want %.6X", palette[0x21], colors[0x21])
+	}
+	// red emphasis darkens green and blue
+	if c := palette[1<<6|0x21]; c>>16 != colors[0x21]>>16 || c&0xFFFF >= colors[0x21]&0xFFFF {
+		t.Errorf("red emphasized $21 is %.6X", c)
+	}
+
+	// 512 colors, used as they are
+	data = nil
+	for i := 0; i < 512; i++ {
+		data = append(data, byte(i>>8), byte(i), byte(i*3))
+	}
+	palette, err = LoadPalette(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range palette {
+		if want := Color(i>>8)<<16 | Color(byte(i))<<8 | Color(byte(i*3)); c != want {
+			t.Fatalf("color $%.3X is %.6X, want %.6X", i, c, want)
+		}
+	}
+
+	for _, size := range []int{0, 191, 193, 1535, 1537} {
+		_, err := LoadPalette(bytes.NewReader(make([]byte, size)))
+		if err == nil || !strings.Contains(err.Error(), "expected 192 or 1536") {
+			t.Errorf("%d bytes: got error %v", size, err)
+		}
+	}
+}
+
+func TestGenerateNtscPalette(t *testing.T) {
+	palette := GenerateNtscPalette(DefaultNtscParams)
+	// $0F is black, $30 white, and the grays have no color
+	if palette[0x0F] != 0 {
+		t.Errorf("$0F is %.6X", palette[0x0F])
+	}
+	if palette[0x30] != 0xFFFFFF {
+		t.Errorf("$30 is %.6X", palette[0x30])
+	}
+	for _, i := range []int{0x00, 0x10, 0x20, 0x2D} {
+		c := palette[i]
+		if r, g, b := c>>16, c>>8&0xFF, c&0xFF; r != g || g != b {
+			t.Errorf("gray $%.2X is %.6X", i, c)
+		}
+	}
+	// $16 is red, $1A green, $12 blue
+	for i, channel := range map[int]uint{0x16: 16, 0x1A: 8, 0x12: 0} {
+		c := palette[i]
+		for _, other := range []uint{16, 8, 0} {
+			if other != channel && c>>other&0xFF >= c>>channel&0xFF {
+				t.Errorf("$%.2X is %.6X", i, c)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package nes
+
+import "math/rand"
+
+// The contents of RAM at power on aren't defined, and vary between consoles (and
+// between power ons). Games that rely on it are buggy, but it's useful to be
+// able to catch them at it.
+// https://wiki.nesdev.com/w/index.php/CPU_power_up_state
+
+type FillPattern int
+
+const (
+	FillZeros       FillPattern = iota
+	FillOnes                    // $FF
+	FillAlternating             // $00 $00 $00 $00 $FF $FF $FF $FF, as seen on many consoles
+	FillRandom
+)
+
+func (p FillPattern) String() string {
+	switch p {
+	case FillOnes:
+		return "ones"
+	case FillAlternating:
+		return "alternating"
+	case FillRandom:
+		return "random"
+	}
+	return "zeros"
+}
+
+// PowerOnState configures what the console looks like when it's turned on.
+type PowerOnState struct {
+	// Fill is used for work RAM, PRG RAM, CHR RAM, OAM, palette RAM and nametables.
+	// Battery backed PRG RAM keeps its contents, so it isn't filled.
+	Fill FillPattern
+	// RandomAlignment starts the PPU at a random point within the first CPU cycle.
+	RandomAlignment bool
+	// Seed makes FillRandom and RandomAlignment reproducible.
+	Seed int64
+}
+
+// SetPowerOnState sets the power on state, and power cycles the console so it takes effect.
+func (nes *Nes) SetPowerOnState(state PowerOnState) error {
+	nes.powerOnState = state
+	return nes.PowerCycle()
+}
+
+// fill memory according to the power on state, before the CPU starts
+func (nes *Nes) applyPowerOnState() {
+	state := nes.powerOnState
+	rng := rand.New(rand.NewSource(state.Seed))
+	fill := func(mem []byte, mask byte) {
+		for i := range mem {
+			switch state.Fill {
+			case FillZeros:
+				mem[i] = 0
+			case FillOnes:
+				mem[i] = 0xFF
+			case FillAlternating:
+				if i&4 != 0 {
+					mem[i] = 0xFF
+				} else {
+					mem[i] = 0
+				}
+			case FillRandom:
+				mem[i] = byte(rng.Intn(256))
+			}
+			mem[i] &= mask
+		}
+	}
+
+	fill(nes.ram[:], 0xFF)
+	if m, ok := nes.mapper.(PrgRamMapper); ok {
+		fill(m.PrgRam()[nes.cartridge.prgNvramSize:], 0xFF)
+	}
+	if nes.cartridge.chrRam {
+		fill(nes.cartridge.chr, 0xFF)
+	}
+	fill(nes.ppu.oam[:], 0xFF)
+	fill(nes.ppu.palette[:], 0x3F) // palette entries are only 6 bits
+	fill(nes.ppu.vram[:], 0xFF)
+
+	if state.RandomAlignment {
+		// the master clock is divided by 12 for the CPU and by 4 for the PPU (15 and 5
+		// for Dendy), so the PPU can start up to 2 dots into the first CPU cycle. PAL
+		// divides by 16 and 5: up to 3 dots and 1/5, which ppuRemainder keeps track of.
+		if nes.region == RegionPAL {
+			offset := rng.Intn(16)
+			nes.ppu.Emulate(offset / 5)
+			nes.ppuRemainder = offset % 5
+		} else {
+			nes.ppu.Emulate(rng.Intn(3))
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package nes
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPowerOnStateKeepsBattery(t *testing.T) {
+	c, err := LoadCartridge(bytes.NewReader(testRomData(16384, 8192, 1, 1, 0x02)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	console, err := NewNes(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !console.HasBattery() {
+		t.Fatal("no battery")
+	}
+
+	// without a .sav, the RAM starts out clean whatever it's filled with
+	for _, fill := range []FillPattern{FillZeros, FillOnes, FillAlternating, FillRandom} {
+		if err := console.SetPowerOnState(PowerOnState{Fill: fill, Seed: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if console.BatteryDirty() {
+			t.Errorf("%v: battery RAM is dirty after power on", fill)
+		}
+	}
+
+	save := make([]byte, len(console.batteryRam()))
+	for i := range save {
+		save[i] = byte(i * 7)
+	}
+	if err := console.LoadBattery(bytes.NewReader(save)); err != nil {
+		t.Fatal(err)
+	}
+	if err := console.SetPowerOnState(PowerOnState{Fill: FillOnes}); err != nil {
+		t.Fatal(err)
+	}
+	if console.BatteryDirty() || !bytes.Equal(console.batteryRam(), save) {
+		t.Error("battery RAM didn't survive the power cycle")
+	}
+}
+
+func TestRandomAlignment(t *testing.T) {
+	// how far the PPU is ahead of an aligned power on, in fifths of a dot
+	offset := func(console *Nes, seed int64) int {
+		start := func(align bool) int {
+			if err := console.SetPowerOnState(PowerOnState{RandomAlignment: align, Seed: seed}); err != nil {
+				t.Fatal(err)
+			}
+			return int(console.ppu.cycles)*5 + console.ppuRemainder
+		}
+		return start(true) - start(false)
+	}
+
+	for region, want := range map[Region]int{RegionNTSC: 3, RegionPAL: 16, RegionDendy: 3} {
+		console := newTestNes(t)
+		console.SetRegion(region)
+		seen := make(map[int]bool)
+		for seed := int64(0); seed < 500; seed++ {
+			o := offset(console, seed)
+			if region != RegionPAL {
+				if o%5 != 0 {
+					t.Fatalf("%v: PPU is %d/5 dots ahead", region, o)
+				}
+				o /= 5
+			}
+			if o < 0 || o >= want {
+				t.Fatalf("%v: PPU is %d ahead", region, o)
+			}
+			seen[o] = true
+		}
+		if len(seen) != want {
+			t.Errorf("%v: got %d different offsets, want %d", region, len(seen), want)
+		}
+	}
+}
@@ -0,0 +1,692 @@
+package nes
+
+type Ppu struct {
+	nes *Nes
+	mem Memory
+
+	vram          [2048]byte
+	oam           [256]byte
+	secondary_oam [32]byte
+	palette       [32]byte
+	colors        Palette
+
+	// region dependent timing
+	lastScanline   int // scanlines are numbered from -1 (prerender) to this
+	vblankScanline int
+	skipOddFrames  bool // whether the prerender scanline is a dot shorter on odd frames
+	swapEmphasis   bool // PAL and Dendy PPUs swap the red and green emphasis bits
+
+	warmup          bool // after power on or reset, writes to some registers are ignored until the first pre-render line
+	scanlineCounter int
+	tickCounter     int
+	frameCounter    int
+	cycles          uint64
+
+	status_rendering    bool
+	flag_vBlank         byte
+	flag_sprite0Hit     byte
+	flag_spriteOverflow byte
+
+	// etc.
+	ppuDataBuffer byte
+
+	// scrolling / internal registers
+	ppuLatch             byte
+	v                    uint16
+	t                    uint16
+	x                    byte
+	w                    byte
+	backgroundBitmapData uint64
+
+	// sprite rendering
+	spriteEvaluationN         int
+	spriteEvaluationM         int
+	spriteEvaluationRead      byte
+	pendingNumScanlineSprites int
+	numScanlineSprites        int // sprites found by evaluation (at most 8)
+	numDisplaySprites         int // sprites drawn: more than numScanlineSprites if unlimitedSprites
+	spriteXPositions          [64]int
+	spriteAttributes          [64]byte
+	spriteBitmapDataLo        [64]byte
+	spriteBitmapDataHi        [64]byte
+	spriteZeroAt              int // slot sprite 0 is in, or -1
+	spriteZeroAtNext          int
+	unlimitedSprites          bool // draw every sprite on a scanline, not just the first 8
+
+	// PPUCTRL
+	flag_baseNametable          byte
+	flag_incrementVram          byte
+	flag_spriteTableAddress     byte
+	flag_backgroundTableAddress byte
+	flag_spriteSize             byte
+	flag_masterSlave            byte
+	flag_generateNMIs           byte
+
+	// PPUMASK
+	flag_grayscale          byte
+	flag_showSpritesLeft    byte
+	flag_showBackgroundLeft byte
+	flag_renderSprites      byte
+	flag_renderBackground   byte
+	flag_emphasizeRed       byte
+	flag_emphasizeGreen     byte
+	flag_emphasizeBlue      byte
+
+	oamAddr byte
+}
+
+func NewPpu(nes *Nes) *Ppu {
+	return &Ppu{
+		nes:              nes,
+		mem:              &PPUMemory{nes: nes},
+		warmup:           true,
+		scanlineCounter:  0, // counts scanlines in a frame ( https://wiki.nesdev.com/w/index.php/PPU_rendering#Line-by-line_timing )
+		tickCounter:      0, // counts clock cycle ticks in a scanline
+		frameCounter:     0, // counts total frames (vblanks)
+		spriteZeroAt:     -1,
+		spriteZeroAtNext: -1,
+
+		flag_vBlank: 0,
+		colors:      DefaultPalette(),
+	}
+}
+
+func (ppu *Ppu) setRegion(region Region) {
+	switch region {
+	case RegionPAL:
+		ppu.lastScanline, ppu.vblankScanline, ppu.skipOddFrames = 310, 241, false
+	case RegionDendy:
+		// vblank starts 50 scanlines later, so the NMI handler's timing is like NTSC
+		ppu.lastScanline, ppu.vblankScanline, ppu.skipOddFrames = 310, 291, false
+	default:
+		ppu.lastScanline, ppu.vblankScanline, ppu.skipOddFrames = 260, 241, true
+	}
+	ppu.swapEmphasis = region != RegionNTSC
+}
+
+func (ppu *Ppu) ReadRegister(register int) byte {
+	switch register {
+	case 2:
+		// PPUSTATUS
+		var status byte = ppu.ppuLatch & 0x1F
+		status |= ppu.flag_spriteOverflow << 5
+		status |= ppu.flag_sprite0Hit << 6
+		status |= ppu.flag_vBlank << 7
+
+		ppu.flag_vBlank = 0
+		ppu.ppuLatch = status
+		ppu.w = 0
+		return status
+	case 4:
+		// OAMDATA
+		// TODO if visible scanline and cycle between 1-64, return 0xFF
+		return ppu.oam[ppu.oamAddr]
+		// XXX increment after read during rendering?
+	case 7:
+		// PPUDATA
+		var data byte
+		if ppu.v <= 0x3EFF {
+			// buffer this read
+			data = ppu.mem.Read(address(ppu.v))
+			ppu.ppuDataBuffer, data = data, ppu.ppuDataBuffer
+		} else {
+			// palette reads aren't buffered (the buffer gets the nametable "underneath"),
+			// and go through the same grayscale masking as the output
+			data = ppu.mem.Read(address(ppu.v))
+			if ppu.flag_grayscale != 0 {
+				data &= 0x30
+			}
+			ppu.ppuDataBuffer = ppu.mem.Read(address(ppu.v - 0x1000))
+		}
+
+		// fmt.Printf("read PPUDATA: $%.4X (got $%.2X) | PC: $%.4X\n", ppu.v, data, nes.cpu.PC)
+		if ppu.flag_incrementVram == 0 {
+			ppu.v += 1
+		} else {
+			ppu.v += 32
+		}
+		return data
+	default:
+		return ppu.ppuLatch
+	}
+}
+
+// https://wiki.nesdev.com/w/index.php/PPU_power_up_state
+func (ppu *Ppu) reset() {
+	ppu.setControl(0)
+	ppu.setMask(0)
+	ppu.w = 0
+	ppu.t = 0
+	ppu.x = 0
+	ppu.ppuDataBuffer = 0
+	ppu.warmup = true
+}
+
+func (ppu *Ppu) setControl(data byte) {
+	ppu.flag_baseNametable = data & 0x3
+	ppu.flag_incrementVram = data & 0x4 >> 2
+	ppu.flag_spriteTableAddress = data & 0x8 >> 3
+	ppu.flag_backgroundTableAddress = data & 0x10 >> 4
+	ppu.flag_spriteSize = data & 0x20 >> 5
+	ppu.flag_masterSlave = data & 0x40 >> 6
+	ppu.flag_generateNMIs = data & 0x80 >> 7
+	ppu.t = (ppu.t & 0xF3FF) | ((uint16(data) & 0x03) << 10)
+}
+
+func (ppu *Ppu) setMask(data byte) {
+	ppu.flag_grayscale = data & 0x1 >> 0
+	ppu.flag_showBackgroundLeft = data & 0x2 >> 1
+	ppu.flag_showSpritesLeft = data & 0x4 >> 2
+	ppu.flag_renderBackground = data & 0x8 >> 3
+	ppu.flag_renderSprites = data & 0x10 >> 4
+	ppu.flag_emphasizeRed = data & 0x20 >> 5
+	ppu.flag_emphasizeGreen = data & 0x40 >> 6
+	ppu.flag_emphasizeBlue = data & 0x80 >> 7
+}
+
+func (ppu *Ppu) WriteRegister(register int, data byte) {
+	ppu.ppuLatch = data
+	if ppu.warmup && (register == 0 || register == 1 || register == 5 || register == 6) {
+		return
+	}
+	switch register {
+	case 0:
+		// PPUCTRL
+		ppu.setControl(data)
+	case 1:
+		// PPUMASK
+		ppu.setMask(data)
+	case 3:
+		// OAMADDR
+		ppu.oamAddr = data
+	case 4:
+		// OAMDATA
+		if !ppu.status_rendering {
+			ppu.oam[ppu.oamAddr] = data
+			ppu.oamAddr++
+		}
+	case 5:
+		// PPUSCROLL
+		// https://wiki.nesdev.com/w/index.php/PPU_scrolling#Register_controls
+		if ppu.w == 0 {
+			ppu.t = (ppu.t & 0xFFE0) | (uint16(data) >> 3)
+			ppu.x = data & 0x7
+			ppu.w = 1
+		} else {
+			ppu.t = (ppu.t & 0x8C1F) | ((uint16(data) & 0xF8) << 2) | ((uint16(data) & 0x7) << 12)
+			ppu.w = 0
+		}
+	case 6:
+		// PPUADDR
+		if ppu.w == 0 {
+			ppu.t = (ppu.t & 0x80FF) | ((uint16(data) & 0x3F) << 8)
+			ppu.w = 1
+		} else {
+			ppu.t = (ppu.t & 0xFF00) | uint16(data)
+			ppu.v = ppu.t
+			ppu.w = 0
+		}
+		// fmt.Printf("PPUADDR : %.2X   %d  = (%.4X) %.4X (via %.4X)\n", data, ppu.w, ppu.t, ppu.v, nes.cpu.PC)
+	case 7:
+		// PPUDATA
+		//fmt.Printf("PPUDATA : %.2X  %d\n", data, ppu.flag_incrementVram)
+		ppu.mem.Write(address(ppu.v), data)
+		if ppu.flag_incrementVram == 0 {
+			ppu.v += 1
+		} else {
+			ppu.v += 32
+		}
+	case 0x4014:
+		// OAMDMA
+		ppu.nes.cpu.startOamDma(data)
+	}
+}
+
+func (ppu *Ppu) Emulate(cycles int) {
+	cycles_left := cycles
+	for cycles_left > 0 {
+		ppu.cycles++
+		ppu.tickCounter++
+		if ppu.tickCounter == 341 || (ppu.skipOddFrames && ppu.tickCounter == 340 && ppu.scanlineCounter == -1 && ppu.frameCounter%2 == 1) {
+			ppu.tickCounter = 0
+			ppu.scanlineCounter++
+			if ppu.scanlineCounter > ppu.lastScanline {
+				ppu.scanlineCounter = -1
+			}
+		}
+
+		if ppu.scanlineCounter == ppu.vblankScanline && ppu.tickCounter == 1 {
+			// VBLANK
+			if ppu.nes.video != nil {
+				ppu.nes.video.PushFrame()
+			}
+			if ppu.flag_generateNMIs == 1 {
+				ppu.nes.cpu.triggerInterruptNMI()
+			}
+			ppu.flag_vBlank = 1
+			ppu.frameCounter += 1
+			ppu.status_rendering = false
+		}
+		cycles_left--
+
+		renderingEnabled := ppu.flag_renderBackground != 0 || ppu.flag_renderSprites != 0
+
+		if ppu.scanlineCounter == -1 {
+			if ppu.tickCounter == 1 {
+				// prerender
+				ppu.flag_sprite0Hit = 0
+				ppu.flag_vBlank = 0
+				ppu.flag_spriteOverflow = 0
+				ppu.status_rendering = true
+				ppu.warmup = false
+				// nothing is evaluated here, so there are no sprites on scanline 0
+				ppu.pendingNumScanlineSprites = 0
+				ppu.spriteZeroAtNext = -1
+			}
+			if ppu.tickCounter == 304 && renderingEnabled {
+				// copy vertical scroll bits
+				// v: IHGF.ED CBA..... = t: IHGF.ED CBA.....
+				ppu.v = (ppu.v & 0x841F) | (ppu.t & 0x7BE0)
+			}
+		}
+
+		// visible rendered scanlines and the prerender scanline
+		// (which does all the same memory fetches, without drawing or evaluating sprites)
+		if ppu.scanlineCounter < 240 && renderingEnabled {
+			visible := ppu.scanlineCounter >= 0
+
+			/* ***** SPRITE EVALUATION ***** */
+			if visible && ppu.tickCounter >= 1 && ppu.tickCounter <= 64 {
+				// https://wiki.nesdev.com/w/index.php/PPU_sprite_evaluation
+				// Sprite Evaluation Stage 1: Clearing the Secondary OAM
+				if ppu.tickCounter%2 == 0 {
+					ppu.secondary_oam[(ppu.tickCounter-1)/2] = 0xFF
+				}
+			}
+			if visible && ppu.tickCounter == 65 {
+				ppu.spriteEvaluationN = 0
+				ppu.spriteEvaluationM = 0
+				ppu.pendingNumScanlineSprites = 0
+				ppu.spriteZeroAtNext = -1
+			}
+			if visible && ppu.tickCounter >= 65 && ppu.tickCounter <= 256 {
+				// Sprite Evaluation Stage 2: Loading the Secondary OAM
+				if ppu.spriteEvaluationN < 64 {
+					if ppu.tickCounter%2 == 1 {
+						// read from primary
+						ppu.spriteEvaluationRead = ppu.oam[4*ppu.spriteEvaluationN+ppu.spriteEvaluationM]
+					} else if ppu.pendingNumScanlineSprites == 8 {
+						// Secondary OAM is full, so the rest of OAM is only checked for sprite overflow.
+						// Because of a hardware bug, m is incremented along with n when a sprite isn't in
+						// range, so the bytes checked go diagonally through OAM instead of being Y positions.
+						if ppu.spriteInRange(ppu.spriteEvaluationRead) {
+							ppu.flag_spriteOverflow = 1
+							// (the next few bytes are read as if copying a sprite, but nothing else is visible)
+							ppu.spriteEvaluationN = 64
+						} else {
+							ppu.spriteEvaluationN++
+							ppu.spriteEvaluationM = (ppu.spriteEvaluationM + 1) & 3
+						}
+					} else {
+						// write to secondary
+						ppu.secondary_oam[4*ppu.pendingNumScanlineSprites+ppu.spriteEvaluationM] = ppu.spriteEvaluationRead
+						if ppu.spriteEvaluationM == 0 && !ppu.spriteInRange(ppu.spriteEvaluationRead) {
+							// not in range.
+							ppu.spriteEvaluationM--
+							ppu.spriteEvaluationN++
+						}
+						if ppu.spriteEvaluationM == 3 {
+							if ppu.spriteEvaluationN == 0 {
+								ppu.spriteZeroAtNext = ppu.pendingNumScanlineSprites
+							}
+							ppu.spriteEvaluationN++
+							ppu.spriteEvaluationM = 0
+							ppu.pendingNumScanlineSprites += 1
+						} else {
+							ppu.spriteEvaluationM++
+						}
+					}
+				}
+			}
+			if ppu.tickCounter >= 257 && ppu.tickCounter <= 320 {
+				ppu.spriteEvaluationN = (ppu.tickCounter - 257) / 8
+				ppu.numScanlineSprites = ppu.pendingNumScanlineSprites
+				ppu.numDisplaySprites = ppu.numScanlineSprites
+				ppu.spriteZeroAt = ppu.spriteZeroAtNext
+				if (ppu.tickCounter-257)%8 == 0 {
+					// fetch x position, attribute into temporary latches and counters
+					n := ppu.spriteEvaluationN
+					if n < ppu.numScanlineSprites {
+						sprite := ppu.secondary_oam[n*4 : n*4+4]
+						ppu.loadSprite(n, sprite[0], sprite[1], sprite[2], sprite[3], false)
+					} else {
+						// unused slots still fetch (tile $FF), which mappers watching A12 can see
+						ppu.loadSprite(n, byte(ppu.scanlineCounter), 0xFF, 0xFF, 0xFF, false)
+					}
+				}
+				if visible && ppu.tickCounter == 320 && ppu.unlimitedSprites && ppu.numScanlineSprites == 8 {
+					ppu.loadExtraSprites()
+				}
+			}
+			/* ***** END SPRITE EVALUATION ***** */
+
+			/* ***** DRAWING ! ***************** */
+			if visible && ppu.tickCounter >= 1 && ppu.tickCounter <= 256 {
+				ppu.renderPixel()
+			}
+
+			// fetching tile data
+			if ppu.scanlineCounter < 240 {
+				if (ppu.tickCounter >= 1 && ppu.tickCounter <= 256) || (ppu.tickCounter >= 321 && ppu.tickCounter <= 336) {
+					ppu.backgroundBitmapData <<= 4
+
+					if ppu.tickCounter%8 == 0 {
+						ppu.fetchTileData()
+					}
+				}
+			}
+
+			/* ***** UPDATE SCROLLING ********** */
+			if ppu.tickCounter == 256 {
+				ppu.incrementScrollY()
+			}
+			if ppu.tickCounter == 257 {
+				// copy horizontal bits from t to v
+				// v: ....F.. ...EDCBA = t: ....F.. ...EDCBA
+				ppu.v = (ppu.v & 0xFBE0) | (ppu.t & 0x41F)
+			}
+			if ((ppu.tickCounter >= 321 && ppu.tickCounter <= 336) || (ppu.tickCounter >= 1 && ppu.tickCounter <= 256)) && (ppu.tickCounter%8 == 0) {
+				ppu.incrementScrollX()
+			}
+		}
+	}
+}
+
+// loadSprite fetches a sprite's pattern for the next scanline into a slot's shift
+// registers. Fetches that real hardware doesn't do are peeks, so mappers don't see them.
+func (ppu *Ppu) loadSprite(slot int, ypos, tile, attribute, xpos byte, peek bool) {
+	ppu.spriteXPositions[slot], ppu.spriteAttributes[slot] = int(xpos), attribute
+
+	spriteTable := ppu.flag_spriteTableAddress
+	tileRow := ppu.scanlineCounter - int(ypos)
+	flipVertical := attribute & 0x80 >> 7
+	if ppu.flag_spriteSize != 0 {
+		// 8x16 sprites: the top and bottom tiles swap when flipped
+		spriteTable = tile & 0x1
+		tile = tile & 0xFE
+		if tileRow >= 8 {
+			tile |= 1 - flipVertical
+			tileRow -= 8
+		} else {
+			tile |= flipVertical
+		}
+	}
+
+	// fetch bitmap data into shift registers
+	if flipVertical != 0 {
+		tileRow = 7 - tileRow
+	}
+	var patternAddr address = 0
+	patternAddr |= address(tileRow)
+	patternAddr |= address(tile) << 4
+	patternAddr |= address(spriteTable) << 12
+	var lo, hi byte
+	if peek {
+		lo, hi = ppu.peekPattern(patternAddr), ppu.peekPattern(patternAddr+8)
+	} else {
+		lo, hi = ppu.mem.Read(patternAddr), ppu.mem.Read(patternAddr+8)
+	}
+
+	if attribute&0x40 > 0 {
+		// flip sprite horizontally
+		var hi2, lo2 byte
+		for i := 0; i < 8; i++ {
+			hi2 = (hi2 << 1) | (hi & 1)
+			lo2 = (lo2 << 1) | (lo & 1)
+			hi >>= 1
+			lo >>= 1
+		}
+		lo, hi = lo2, hi2
+	}
+
+	ppu.spriteBitmapDataLo[slot] = lo
+	ppu.spriteBitmapDataHi[slot] = hi
+}
+
+// loadExtraSprites loads the sprites on the next scanline after the first 8 (which
+// evaluation found), for display only. Nothing the CPU or mapper can see changes.
+func (ppu *Ppu) loadExtraSprites() {
+	found := 0
+	for n := 0; n < 64; n++ {
+		sprite := ppu.oam[n*4 : n*4+4]
+		if !ppu.spriteInRange(sprite[0]) {
+			continue
+		}
+		found++
+		if found > ppu.numScanlineSprites {
+			ppu.loadSprite(ppu.numDisplaySprites, sprite[0], sprite[1], sprite[2], sprite[3], true)
+			ppu.numDisplaySprites++
+		}
+	}
+}
+
+// peekPattern reads from the pattern tables without the mapper seeing it.
+func (ppu *Ppu) peekPattern(addr address) byte {
+	if m, ok := ppu.nes.mapper.(PeekMapper); ok {
+		return m.Peek(addr)
+	}
+	return ppu.mem.Read(addr)
+}
+
+// whether a sprite with this Y position is on the current scanline
+func (ppu *Ppu) spriteInRange(y byte) bool {
+	spriteHeight := 8
+	if ppu.flag_spriteSize != 0 {
+		spriteHeight = 16
+	}
+	row := ppu.scanlineCounter - int(y)
+	return row >= 0 && row < spriteHeight
+}
+
+func (ppu *Ppu) renderPixel() {
+	x, y := ppu.tickCounter-1, ppu.scanlineCounter
+
+	// background pixel
+	backgroundPixel := byte(ppu.backgroundBitmapData >> (32 + ((7 - ppu.x) * 4)) & 0xF)
+
+	// sprite pixel
+	var spritePixel byte = 0
+	var spriteIndex = 0
+	for n := 0; n < ppu.numDisplaySprites; n++ {
+		offset := x - int(ppu.spriteXPositions[n])
+		if offset >= 0 && offset < 8 {
+			attributes := ppu.spriteAttributes[n]
+			data := ((ppu.spriteBitmapDataHi[n] & 0x80) >> 6) | ((ppu.spriteBitmapDataLo[n] & 0x80) >> 7)
+			// every sprite shifts, even behind another one
+			ppu.spriteBitmapDataHi[n] <<= 1
+			ppu.spriteBitmapDataLo[n] <<= 1
+			if data != 0 && spritePixel == 0 {
+				// the first opaque sprite pixel wins
+				spritePixel = 0x10 + data + 4*(attributes&0x3)
+				spriteIndex = n
+			}
+		}
+	}
+
+	// left screen hiding
+	if x < 8 {
+		if ppu.flag_showBackgroundLeft == 0 {
+			backgroundPixel = 0
+		}
+		if ppu.flag_showSpritesLeft == 0 {
+			spritePixel = 0
+		}
+	}
+
+	var output byte = 0
+	bgVisible, spVisible := backgroundPixel%4 != 0, spritePixel%4 != 0
+	if !bgVisible && !spVisible {
+		output = 0
+	} else if !bgVisible {
+		output = spritePixel
+	} else if !spVisible {
+		output = backgroundPixel
+	} else {
+		// https://wiki.nesdev.com/w/index.php/PPU_OAM#Sprite_zero_hits
+		if spriteIndex == ppu.spriteZeroAt && x != 255 {
+			ppu.flag_sprite0Hit = 1
+		}
+
+		spriteHasPriority := ppu.spriteAttributes[spriteIndex]&0x20 == 0
+		if spriteHasPriority {
+			output = spritePixel
+		} else {
+			output = backgroundPixel
+		}
+	}
+
+	if ppu.nes.video != nil {
+		ppu.nes.video.PushPixel(x, y, ppu.FetchColor(output))
+		if ppu.nes.rawVideo != nil {
+			// a pixel is 8 of the 12 subcarrier phases
+			ppu.nes.rawVideo.PushRawPixel(x, y, ppu.outputValue(output), int(ppu.cycles%3)*8%12)
+		}
+	}
+}
+
+func (ppu *Ppu) fetchTileData() {
+	// run on (_ % 8 == 1) ticks in prerender and render scanlines
+	// we need to fetch a tile AND the attribute data, combine them, and
+	// shove them onto our queue of uh, stuff
+	nametableAddress := 0x2000 | (ppu.v & 0x0FFF)
+	nametableData := ppu.mem.Read(address(nametableAddress))
+	attributeAddress := 0x23C0 | (ppu.v & 0x0C00) | ((ppu.v >> 4) & 0x38) | ((ppu.v >> 2) & 0x07)
+	attributeData := ppu.mem.Read(address(attributeAddress))
+	// process attribute data to select correct tile
+	attributeData = ((attributeData >> (((ppu.v >> 4) & 4) | (ppu.v & 2))) & 3) << 2
+
+	var patternAddr address = 0
+	patternAddr |= address((ppu.v >> 12) & 0x7)
+	patternAddr |= address(nametableData) << 4
+	patternAddr |= address(ppu.flag_backgroundTableAddress) << 12
+	patternLo, patternHi := ppu.mem.Read(patternAddr), ppu.mem.Read(patternAddr+8)
+
+	var bitmap uint32 = 0
+	for i := 0; i < 8; i++ {
+		// shift on the data
+		pixelData := attributeData | ((patternLo & 0x80) >> 7) | ((patternHi & 0x80) >> 6)
+		patternLo <<= 1
+		patternHi <<= 1
+		bitmap = (bitmap << 4) | uint32(pixelData)
+	}
+
+	ppu.backgroundBitmapData |= uint64(bitmap)
+}
+
+func (ppu *Ppu) incrementScrollY() {
+	if ppu.v&0x7000 != 0x7000 {
+		ppu.v += 0x1000
+	} else {
+		ppu.v &= 0x8FFF
+		y := (ppu.v & 0x03E0) >> 5
+		if y == 29 {
+			y = 0
+			ppu.v ^= 0x0800
+		} else if y == 31 {
+			y = 0
+		} else {
+			y += 1
+		}
+		ppu.v = (ppu.v & 0xFC1F) | (y << 5)
+	}
+}
+
+func (ppu *Ppu) incrementScrollX() {
+	if ppu.v&0x001F == 31 {
+		ppu.v &= 0xFFE0
+		ppu.v ^= 0x0400
+	} else {
+		ppu.v += 1
+	}
+}
+
+// FetchColor looks up the color of a background/sprite palette index, with the
+// PPUMASK grayscale and color emphasis bits applied.
+// https://wiki.nesdev.com/w/index.php/PPU_registers#Color_effects
+func (ppu *Ppu) FetchColor(index byte) Color {
+	return ppu.colors[ppu.outputValue(index)]
+}
+
+// the 9 bit value the PPU outputs for a palette index: emphasis bits (BGR) and a 6 bit color
+func (ppu *Ppu) outputValue(index byte) uint16 {
+	value := ppu.palette[index&0x1F] & 0x3F
+	if ppu.flag_grayscale != 0 {
+		// only the brightness bits are kept, which selects the gray column
+		value &= 0x30
+	}
+	red, green := ppu.flag_emphasizeRed, ppu.flag_emphasizeGreen
+	if ppu.swapEmphasis {
+		red, green = green, red
+	}
+	emphasis := uint16(red | green<<1 | ppu.flag_emphasizeBlue<<2)
+	return emphasis<<6 | uint16(value)
+}
+
+func (ppu *Ppu) Serialize(s *StateStream) {
+	s.Bytes(ppu.vram[:])
+	s.Bytes(ppu.oam[:])
+	s.Bytes(ppu.secondary_oam[:])
+	s.Bytes(ppu.palette[:])
+
+	s.Bool(&ppu.warmup)
+	s.Int(&ppu.scanlineCounter)
+	s.Int(&ppu.tickCounter)
+	s.Int(&ppu.frameCounter)
+	s.Uint64(&ppu.cycles)
+
+	s.Bool(&ppu.status_rendering)
+	s.Byte(&ppu.flag_vBlank)
+	s.Byte(&ppu.flag_sprite0Hit)
+	s.Byte(&ppu.flag_spriteOverflow)
+
+	s.Byte(&ppu.ppuDataBuffer)
+
+	s.Byte(&ppu.ppuLatch)
+	s.Uint16(&ppu.v)
+	s.Uint16(&ppu.t)
+	s.Byte(&ppu.x)
+	s.Byte(&ppu.w)
+	s.Uint64(&ppu.backgroundBitmapData)
+
+	s.Int(&ppu.spriteEvaluationN)
+	s.Int(&ppu.spriteEvaluationM)
+	s.Byte(&ppu.spriteEvaluationRead)
+	s.Int(&ppu.pendingNumScanlineSprites)
+	s.Int(&ppu.numScanlineSprites)
+	s.Int(&ppu.numDisplaySprites)
+	s.Ints(ppu.spriteXPositions[:])
+	s.Bytes(ppu.spriteAttributes[:])
+	s.Bytes(ppu.spriteBitmapDataLo[:])
+	s.Bytes(ppu.spriteBitmapDataHi[:])
+	s.Int(&ppu.spriteZeroAt)
+	s.Int(&ppu.spriteZeroAtNext)
+
+	s.Byte(&ppu.flag_baseNametable)
+	s.Byte(&ppu.flag_incrementVram)
+	s.Byte(&ppu.flag_spriteTableAddress)
+	s.Byte(&ppu.flag_backgroundTableAddress)
+	s.Byte(&ppu.flag_spriteSize)
+	s.Byte(&ppu.flag_masterSlave)
+	s.Byte(&ppu.flag_generateNMIs)
+
+	s.Byte(&ppu.flag_grayscale)
+	s.Byte(&ppu.flag_showSpritesLeft)
+	s.Byte(&ppu.flag_showBackgroundLeft)
+	s.Byte(&ppu.flag_renderSprites)
+	s.Byte(&ppu.flag_renderBackground)
+	s.Byte(&ppu.flag_emphasizeRed)
+	s.Byte(&ppu.flag_emphasizeGreen)
+	s.Byte(&ppu.flag_emphasizeBlue)
+
+	s.Byte(&ppu.oamAddr)
+}
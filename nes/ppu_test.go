@@ -0,0 +1,89 @@
+package nes
+
+import "testing"
+
+// spriteTestOam puts sprites 0 to `count`-1 at Y $64 (so on scanlines 100-107) with
+// tile 0, and fills everything else with $F0, off screen
+func spriteTestOam(count int) (oam [256]byte) {
+	for i := range oam {
+		oam[i] = 0xF0
+	}
+	for n := 0; n < count; n++ {
+		oam[n*4], oam[n*4+1], oam[n*4+2], oam[n*4+3] = 0x64, 0, 0, byte(16+n*8)
+	}
+	return
+}
+
+// runSpriteFrame renders a frame with the given OAM, where every tile is solid,
+// returning PPUSTATUS at the start of each scanline
+func runSpriteFrame(t *testing.T, oam [256]byte, unlimited bool) (status []byte) {
+	t.Helper()
+	console := newTestNes(t)
+	ppu := console.ppu
+	for i := 0; i < 8; i++ {
+		console.cartridge.chr[i] = 0xFF
+	}
+	ppu.oam = oam
+	ppu.unlimitedSprites = unlimited
+	ppu.flag_renderBackground, ppu.flag_renderSprites = 1, 1
+	for ppu.scanlineCounter != -1 {
+		ppu.Emulate(1)
+	}
+	for ppu.scanlineCounter != 241 {
+		ppu.Emulate(1)
+		if ppu.tickCounter == 0 {
+			status = append(status, ppu.flag_spriteOverflow<<5|ppu.flag_sprite0Hit<<6)
+		}
+	}
+	return
+}
+
+func spriteOverflow(status []byte) bool {
+	return status[len(status)-1]&0x20 != 0
+}
+
+func TestSpriteOverflow(t *testing.T) {
+	if spriteOverflow(runSpriteFrame(t, spriteTestOam(8), false)) {
+		t.Error("8 sprites overflowed")
+	}
+	if !spriteOverflow(runSpriteFrame(t, spriteTestOam(9), false)) {
+		t.Error("9 sprites didn't overflow")
+	}
+
+	// after 8 sprites are found, a sprite that's out of range increments m along with n,
+	// so for the next sprite it's the tile number that's compared, not the Y position
+	oam := spriteTestOam(8)
+	oam[9*4], oam[9*4+1] = 0x64, 0xF0 // in range, with an out of range tile
+	if spriteOverflow(runSpriteFrame(t, oam, false)) {
+		t.Error("overflow found the 9th sprite on a line: there's no false negative")
+	}
+	oam = spriteTestOam(8)
+	oam[9*4+1] = 0x64 // out of range, with a tile that's in range
+	if !spriteOverflow(runSpriteFrame(t, oam, false)) {
+		t.Error("overflow didn't go by the tile number: there's no false positive")
+	}
+}
+
+func TestUnlimitedSpritesStatus(t *testing.T) {
+	// drawing the extra sprites mustn't change sprite 0 hit or overflow, or when they happen
+	falsePositive := spriteTestOam(8)
+	falsePositive[9*4+1] = 0x64
+	for name, oam := range map[string][256]byte{
+		"8 sprites":      spriteTestOam(8),
+		"12 sprites":     spriteTestOam(12),
+		"false positive": falsePositive,
+	} {
+		limited := runSpriteFrame(t, oam, false)
+		unlimited := runSpriteFrame(t, oam, true)
+		if limited[len(limited)-1]&0x40 == 0 {
+			t.Errorf("%s: no sprite 0 hit", name)
+		}
+		for line := range limited {
+			if limited[line] != unlimited[line] {
+				t.Errorf("%s: PPUSTATUS is $%.2X on scanline %d with unlimited sprites, want $%.2X",
+					name, unlimited[line], line, limited[line])
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package nes
+
+// Region selects the console's video standard and clock rates.
+// https://wiki.nesdev.com/w/index.php/Cycle_reference_chart
+type Region int
+
+const (
+	RegionNTSC Region = iota
+	RegionPAL
+	RegionDendy
+)
+
+func (r Region) String() string {
+	switch r {
+	case RegionPAL:
+		return "PAL"
+	case RegionDendy:
+		return "Dendy"
+	}
+	return "NTSC"
+}
+
+// RegionForTiming returns the region a cartridge with the given header timing should run on.
+func RegionForTiming(timing int) Region {
+	switch timing {
+	case TimingPAL:
+		return RegionPAL
+	case TimingDendy:
+		return RegionDendy
+	}
+	return RegionNTSC
+}
+
+// SetRegion switches CPU, PPU and APU timing to that of `region`.
+func (nes *Nes) SetRegion(region Region) {
+	nes.region = region
+	nes.ppu.setRegion(region)
+	nes.apu.setRegion(region)
+}
+
+func (nes *Nes) Region() Region {
+	return nes.region
+}
+
+// FrameRate returns the number of frames per second, for frontends pacing themselves.
+func (nes *Nes) FrameRate() float64 {
+	switch nes.region {
+	case RegionPAL:
+		return 1662607.0 * 16 / 5 / (341 * 312)
+	case RegionDendy:
+		return 1773448.0 * 3 / (341 * 312)
+	}
+	// one dot shorter every other frame
+	return 1789773.0 * 3 / (341*262 - 0.5)
+}
@@ -0,0 +1,224 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Save state file layout (all little endian):
+//
+//	magic   "NESS"
+//	version uint16
+//	crc32   uint32 (of the cartridge PRG+CHR, so states aren't loaded into the wrong game)
+//	sections, each: tag [4]byte, length uint32, payload
+//
+// Bump stateVersion whenever any section's payload changes.
+const stateMagic = "NESS"
+const stateVersion = 9
+
+var stateSections = []string{"SYS ", "CPU ", "PPU ", "APU ", "RAM ", "CTRL", "CART", "MAPR"}
+
+// StateStream serializes state in both directions: components describe their
+// fields once, passing pointers, and the stream either reads into them or writes them out.
+type StateStream struct {
+	loading bool
+	buf     *bytes.Buffer
+	err     error
+}
+
+func newStateWriter() *StateStream {
+	return &StateStream{buf: &bytes.Buffer{}}
+}
+
+func newStateReader(data []byte) *StateStream {
+	return &StateStream{loading: true, buf: bytes.NewBuffer(data)}
+}
+
+func (s *StateStream) raw(data []byte) {
+	if s.err != nil {
+		return
+	}
+	if s.loading {
+		_, err := io.ReadFull(s.buf, data)
+		if err != nil {
+			s.err = errors.New("save state section is truncated")
+		}
+	} else {
+		s.buf.Write(data)
+	}
+}
+
+func (s *StateStream) Bytes(v []byte) {
+	s.raw(v)
+}
+
+func (s *StateStream) Byte(v *byte) {
+	b := []byte{*v}
+	s.raw(b)
+	*v = b[0]
+}
+
+func (s *StateStream) Bool(v *bool) {
+	var b byte
+	if *v {
+		b = 1
+	}
+	s.Byte(&b)
+	*v = b != 0
+}
+
+func (s *StateStream) Uint16(v *uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], *v)
+	s.raw(b[:])
+	*v = binary.LittleEndian.Uint16(b[:])
+}
+
+func (s *StateStream) Uint64(v *uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], *v)
+	s.raw(b[:])
+	*v = binary.LittleEndian.Uint64(b[:])
+}
+
+func (s *StateStream) Address(v *address) {
+	a := uint16(*v)
+	s.Uint16(&a)
+	*v = address(a)
+}
+
+func (s *StateStream) Int(v *int) {
+	i := uint64(int64(*v))
+	s.Uint64(&i)
+	*v = int(int64(i))
+}
+
+func (s *StateStream) Ints(v []int) {
+	for i := range v {
+		s.Int(&v[i])
+	}
+}
+
+// SaveState writes a snapshot of the whole machine.
+func (nes *Nes) SaveState(w io.Writer) error {
+	var out bytes.Buffer
+	out.WriteString(stateMagic)
+	binary.Write(&out, binary.LittleEndian, uint16(stateVersion))
+	_, _, crc := nes.cartridge.CRC32()
+	binary.Write(&out, binary.LittleEndian, crc)
+
+	for _, tag := range stateSections {
+		s := newStateWriter()
+		nes.serializeSection(tag, s)
+		out.WriteString(tag)
+		binary.Write(&out, binary.LittleEndian, uint32(s.buf.Len()))
+		out.Write(s.buf.Bytes())
+	}
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// LoadState restores a snapshot written by SaveState. If the snapshot is
+// invalid, the machine is left as it was.
+func (nes *Nes) LoadState(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	sections, err := nes.parseState(data)
+	if err != nil {
+		return err
+	}
+
+	var backup bytes.Buffer
+	nes.SaveState(&backup)
+
+	for _, tag := range stateSections {
+		s := newStateReader(sections[tag])
+		nes.serializeSection(tag, s)
+		if s.err == nil && s.buf.Len() != 0 {
+			s.err = errors.New("save state section has trailing data")
+		}
+		if s.err != nil {
+			backupSections, _ := nes.parseState(backup.Bytes())
+			for _, tag := range stateSections {
+				nes.serializeSection(tag, newStateReader(backupSections[tag]))
+			}
+			return fmt.Errorf("section %q: %v", tag, s.err)
+		}
+	}
+	return nil
+}
+
+func (nes *Nes) parseState(data []byte) (map[string][]byte, error) {
+	if len(data) < 10 || string(data[0:4]) != stateMagic {
+		return nil, errors.New("not a save state")
+	}
+	version := binary.LittleEndian.Uint16(data[4:6])
+	if version != stateVersion {
+		return nil, fmt.Errorf("unsupported save state version %d (expected %d)", version, stateVersion)
+	}
+	_, _, crc := nes.cartridge.CRC32()
+	if binary.LittleEndian.Uint32(data[6:10]) != crc {
+		return nil, errors.New("save state is for a different cartridge")
+	}
+
+	sections := make(map[string][]byte)
+	data = data[10:]
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("save state is truncated")
+		}
+		tag := string(data[0:4])
+		length := binary.LittleEndian.Uint32(data[4:8])
+		data = data[8:]
+		if uint32(len(data)) < length {
+			return nil, errors.New("save state is truncated")
+		}
+		sections[tag] = data[:length]
+		data = data[length:]
+	}
+
+	for _, tag := range stateSections {
+		if _, ok := sections[tag]; !ok {
+			return nil, fmt.Errorf("save state is missing section %q", tag)
+		}
+	}
+	return sections, nil
+}
+
+func (nes *Nes) serializeSection(tag string, s *StateStream) {
+	switch tag {
+	case "SYS ":
+		// timing differs too much between regions to switch when loading
+		region := int(nes.region)
+		s.Int(&region)
+		if s.loading && s.err == nil && Region(region) != nes.region {
+			s.err = fmt.Errorf("save state is for %v, not %v", Region(region), nes.region)
+		}
+		s.Int(&nes.ppuRemainder)
+	case "CPU ":
+		nes.cpu.Serialize(s)
+	case "PPU ":
+		nes.ppu.Serialize(s)
+	case "APU ":
+		nes.apu.Serialize(s)
+	case "RAM ":
+		s.Bytes(nes.ram[:])
+	case "CTRL":
+		nes.controller1.Serialize(s)
+		nes.controller2.Serialize(s)
+	case "CART":
+		// CHR RAM lives in the cartridge
+		if nes.cartridge.chrRam {
+			s.Bytes(nes.cartridge.chr)
+		}
+	case "MAPR":
+		nes.mapper.Serialize(s)
+	}
+}
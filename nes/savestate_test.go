@@ -0,0 +1,110 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// turns on NMI, rendering and a pulse channel, then counts in $10 forever
+var stateTestCode = []byte{
+	0x78,       // SEI
+	0xA9, 0x80, // LDA #$80
+	0x8D, 0x00, 0x20, // STA $2000
+	0xA9, 0x1E, // LDA #$1E
+	0x8D, 0x01, 0x20, // STA $2001
+	0xA9, 0x0F, // LDA #$0F
+	0x8D, 0x15, 0x40, // STA $4015
+	0xA9, 0xBF, // LDA #$BF
+	0x8D, 0x00, 0x40, // STA $4000
+	0xA9, 0xFF, // LDA #$FF
+	0x8D, 0x02, 0x40, // STA $4002
+	0xA9, 0x00, // LDA #$00
+	0x8D, 0x03, 0x40, // STA $4003
+	0xE6, 0x10, // INC $10
+	0x4C, 0x1F, 0x80, // JMP $801F
+}
+
+func saveState(t *testing.T, console *Nes) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := console.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	console := newTestNes(t, stateTestCode...)
+	for i := 0; i < 3; i++ {
+		console.EmulateFrame()
+	}
+	start := saveState(t, console)
+	for i := 0; i < 3; i++ {
+		console.EmulateFrame()
+	}
+	want := saveState(t, console)
+	if bytes.Equal(start, want) {
+		t.Fatal("state didn't change while running")
+	}
+
+	if err := console.LoadState(bytes.NewReader(start)); err != nil {
+		t.Fatal(err)
+	}
+	if got := saveState(t, console); !bytes.Equal(got, start) {
+		t.Fatal("loaded state doesn't save back identically")
+	}
+	for i := 0; i < 3; i++ {
+		console.EmulateFrame()
+	}
+	if got := saveState(t, console); !bytes.Equal(got, want) {
+		t.Error("running from a loaded state diverged")
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	console := newTestNes(t, stateTestCode...)
+	console.EmulateFrame()
+	state := saveState(t, console)
+	console.EmulateFrame()
+	current := saveState(t, console)
+
+	// an extra byte at the end of the last section, so every other section is loaded first
+	trailing := append([]byte(nil), state...)
+	trailing = append(trailing, 0)
+	lengthAt := bytes.LastIndex(trailing, []byte("MAPR")) + 4
+	length := binary.LittleEndian.Uint32(trailing[lengthAt:])
+	binary.LittleEndian.PutUint32(trailing[lengthAt:], length+1)
+
+	otherCart := saveState(t, newTestNes(t, 0xEA))
+	pal := newTestNes(t, stateTestCode...)
+	pal.SetRegion(RegionPAL)
+	pal.EmulateFrame()
+	otherRegion := saveState(t, pal)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		error string
+	}{
+		{"empty", nil, "not a save state"},
+		{"bad magic", append([]byte("XXXX"), state[4:]...), "not a save state"},
+		{"bad version", append([]byte("NESS\xFF\xFF"), state[6:]...), "unsupported save state version"},
+		{"other cartridge", otherCart, "different cartridge"},
+		{"other region", otherRegion, "save state is for PAL, not NTSC"},
+		{"truncated", state[:len(state)-1], "truncated"},
+		{"trailing data", trailing, "trailing data"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := console.LoadState(bytes.NewReader(test.data))
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("got error %v, want %q", err, test.error)
+			}
+			if got := saveState(t, console); !bytes.Equal(got, current) {
+				t.Error("failed load changed the machine")
+			}
+		})
+	}
+}
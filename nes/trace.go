@@ -0,0 +1,230 @@
+package nes
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Traces are in the Nintendulator format used by nestest.log, one line per instruction:
+//
+//	C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7
+//
+// with the PPU position as scanline, dot and the total CPU cycles before the instruction.
+// http://www.qmtpro.com/~nes/misc/nestest.log
+
+// lines of matching trace shown before a divergence (and reference lines after it)
+const traceContext = 8
+
+// SetTraceOutput writes a line per executed instruction to `w`, or stops tracing if it's nil.
+func (nes *Nes) SetTraceOutput(w io.Writer) {
+	nes.trace = w
+}
+
+// SetReferenceLog compares execution against a trace (e.g. from another emulator), until
+// they diverge (see TraceDivergence) or the reference ends. nil stops comparing.
+func (nes *Nes) SetReferenceLog(r io.Reader) {
+	nes.traceCompare = nil
+	if r != nil {
+		nes.traceCompare = NewTraceComparator(r, traceContext)
+	}
+}
+
+// TraceDivergence returns the *TraceDivergence where execution stopped matching the
+// reference log, or nil if it hasn't.
+func (nes *Nes) TraceDivergence() error {
+	if nes.traceCompare == nil || nes.traceCompare.divergence == nil {
+		return nil
+	}
+	return nes.traceCompare.divergence
+}
+
+func (nes *Nes) traceInstruction() {
+	line := nes.traceLine()
+	if nes.trace != nil {
+		io.WriteString(nes.trace, line+"\n")
+	}
+	if nes.traceCompare != nil {
+		nes.traceCompare.Compare(line)
+	}
+}
+
+func (nes *Nes) traceLine() string {
+	cpu, ppu := nes.cpu, nes.ppu
+	raw, text := nes.disassemble(cpu.PC)
+	scanline := ppu.scanlineCounter
+	if scanline < 0 {
+		// the pre-render scanline is the last one, not -1
+		scanline = ppu.lastScanline + 1
+	}
+	return fmt.Sprintf("%.4X  %-8s %-33sA:%.2X X:%.2X Y:%.2X P:%.2X SP:%.2X PPU:%3d,%3d CYC:%d",
+		cpu.PC, raw, text, cpu.A, cpu.X, cpu.Y, cpu.statusPack(false), cpu.SP, scanline, ppu.tickCounter, cpu.totalCycles)
+}
+
+/* ***** COMPARISON ***** */
+
+// TraceDivergence is where a trace stops matching its reference.
+type TraceDivergence struct {
+	Line   int      // line number in the reference (from 1)
+	Field  string   // first field that differs: "PC", "bytes", "instruction", "A", "P", "PPU", ...
+	Want   string   // the reference line
+	Got    string   // the traced line
+	Before []string // preceding (matching) lines
+	After  []string // following reference lines
+}
+
+func (d *TraceDivergence) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "trace diverges from reference at line %d (%s)\n", d.Line, d.Field)
+	for _, line := range d.Before {
+		fmt.Fprintf(&b, "   %s\n", line)
+	}
+	fmt.Fprintf(&b, " - %s\n", d.Want)
+	fmt.Fprintf(&b, " + %s\n", d.Got)
+	for _, line := range d.After {
+		fmt.Fprintf(&b, "   %s\n", line)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// TraceComparator checks trace lines one at a time against a reference log.
+type TraceComparator struct {
+	reference  *bufio.Scanner
+	context    int
+	line       int
+	recent     []string
+	done       bool // diverged, or the reference ended
+	divergence *TraceDivergence
+}
+
+// NewTraceComparator compares against `reference`, keeping `context` lines around a divergence.
+func NewTraceComparator(reference io.Reader, context int) *TraceComparator {
+	return &TraceComparator{
+		reference: bufio.NewScanner(reference),
+		context:   context,
+	}
+}
+
+// Compare checks the next line, returning false once the traces have diverged or the reference has ended.
+func (c *TraceComparator) Compare(line string) bool {
+	if c.done {
+		return false
+	}
+	if !c.reference.Scan() {
+		c.done = true
+		return false
+	}
+	c.line++
+	want := c.reference.Text()
+	if field := traceLineDiff(want, line); field != "" {
+		c.done = true
+		c.divergence = &TraceDivergence{
+			Line:   c.line,
+			Field:  field,
+			Want:   want,
+			Got:    line,
+			Before: c.recent,
+		}
+		for i := 0; i < c.context && c.reference.Scan(); i++ {
+			c.divergence.After = append(c.divergence.After, c.reference.Text())
+		}
+		return false
+	}
+	c.recent = append(c.recent, line)
+	if len(c.recent) > c.context {
+		c.recent = c.recent[1:]
+	}
+	return true
+}
+
+// Divergence returns where the traces diverged, or nil.
+func (c *TraceComparator) Divergence() *TraceDivergence {
+	return c.divergence
+}
+
+// CompareTraces compares two complete trace logs, returning the first divergence (or nil).
+// Comparison stops at the end of the shorter one.
+func CompareTraces(got io.Reader, want io.Reader, context int) (*TraceDivergence, error) {
+	c := NewTraceComparator(want, context)
+	lines := bufio.NewScanner(got)
+	for lines.Scan() && c.Compare(lines.Text()) {
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.reference.Err(); err != nil {
+		return nil, err
+	}
+	return c.divergence, nil
+}
+
+var traceRegisterPattern = regexp.MustCompile(`\b(A|X|Y|P|SP|CYC):\s*([0-9A-Fa-f]+)|\bPPU:\s*(\d+),\s*(\d+)`)
+
+// returns the name of the first field differing between two trace lines, or "" if they match.
+// The memory values shown in the disassembly aren't compared (emulators differ in whether
+// they show open bus or register reads), and neither are fields missing from either line.
+func traceLineDiff(want string, got string) string {
+	if traceField(want, 0, 4) != traceField(got, 0, 4) {
+		return "PC"
+	}
+	if traceField(want, 6, 14) != traceField(got, 6, 14) {
+		return "bytes"
+	}
+	if traceInstructionText(want) != traceInstructionText(got) {
+		return "instruction"
+	}
+	wantRegs, gotRegs := traceRegisters(want), traceRegisters(got)
+	for _, name := range []string{"A", "X", "Y", "P", "SP", "PPU", "CYC"} {
+		w, wok := wantRegs[name]
+		g, gok := gotRegs[name]
+		if wok && gok && w != g {
+			return name
+		}
+	}
+	return ""
+}
+
+func traceField(line string, start int, end int) string {
+	if start >= len(line) {
+		return ""
+	}
+	if end > len(line) {
+		end = len(line)
+	}
+	return strings.TrimSpace(line[start:end])
+}
+
+// the mnemonic and operand, without " @ address" or " = value"
+func traceInstructionText(line string) string {
+	text := line
+	if i := strings.Index(text, "A:"); i >= 0 {
+		text = text[:i]
+	}
+	text = traceField(text, 15, len(text))
+	for _, sep := range []string{" @ ", " = "} {
+		if i := strings.Index(text, sep); i >= 0 {
+			text = text[:i]
+		}
+	}
+	return text
+}
+
+func traceRegisters(line string) map[string]string {
+	regs := make(map[string]string)
+	for _, m := range traceRegisterPattern.FindAllStringSubmatch(line, -1) {
+		if m[1] != "" {
+			value := strings.ToUpper(m[2])
+			if m[1] == "CYC" {
+				n, _ := strconv.Atoi(m[2])
+				value = strconv.Itoa(n)
+			}
+			regs[m[1]] = value
+		} else {
+			regs["PPU"] = m[3] + "," + m[4]
+		}
+	}
+	return regs
+}
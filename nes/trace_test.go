@@ -0,0 +1,118 @@
+package nes
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const traceTestLine = "C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7"
+
+func TestTraceLineDiff(t *testing.T) {
+	tests := []struct {
+		name  string
+		got   string
+		field string
+	}{
+		{"same", traceTestLine, ""},
+		{"PC", strings.Replace(traceTestLine, "C000", "C001", 1), "PC"},
+		{"bytes", strings.Replace(traceTestLine, "4C F5 C5", "4C F5 C6", 1), "bytes"},
+		{"instruction", strings.Replace(traceTestLine, "JMP $C5F5", "JSR $C5F5", 1), "instruction"},
+		{"A", strings.Replace(traceTestLine, "A:00", "A:01", 1), "A"},
+		{"P", strings.Replace(traceTestLine, "P:24", "P:25", 1), "P"},
+		{"PPU", strings.Replace(traceTestLine, "PPU:  0, 21", "PPU:  0, 24", 1), "PPU"},
+		{"CYC", strings.Replace(traceTestLine, "CYC:7", "CYC:8", 1), "CYC"},
+		{"missing fields", strings.Replace(traceTestLine, " PPU:  0, 21 CYC:7", "", 1), ""},
+		{"memory value", strings.Replace(traceTestLine, "JMP $C5F5      ", "JMP $C5F5 = 00 ", 1), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if field := traceLineDiff(traceTestLine, test.got); field != test.field {
+				t.Errorf("got %q, want %q", field, test.field)
+			}
+		})
+	}
+}
+
+func TestCompareTraces(t *testing.T) {
+	var want []string
+	for i := 0; i < 10; i++ {
+		want = append(want, strings.Replace(traceTestLine, "CYC:7", "CYC:"+strconv.Itoa(7+i*3), 1))
+	}
+	got := append([]string(nil), want...)
+	got[5] = strings.Replace(got[5], "X:00", "X:80", 1)
+
+	d, err := CompareTraces(strings.NewReader(strings.Join(got, "\n")), strings.NewReader(strings.Join(want, "\n")), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil {
+		t.Fatal("no divergence")
+	}
+	if d.Line != 6 || d.Field != "X" || d.Want != want[5] || d.Got != got[5] {
+		t.Errorf("wrong divergence: line %d, field %s", d.Line, d.Field)
+	}
+	if strings.Join(d.Before, "\n") != strings.Join(want[3:5], "\n") {
+		t.Errorf("wrong context before: %q", d.Before)
+	}
+	if strings.Join(d.After, "\n") != strings.Join(want[6:8], "\n") {
+		t.Errorf("wrong context after: %q", d.After)
+	}
+
+	// a trace that stops early, or runs past the reference, matches
+	d, err = CompareTraces(strings.NewReader(strings.Join(want[:4], "\n")), strings.NewReader(strings.Join(want, "\n")), 2)
+	if err != nil || d != nil {
+		t.Errorf("shorter trace: got %v, %v", d, err)
+	}
+	d, err = CompareTraces(strings.NewReader(strings.Join(want, "\n")), strings.NewReader(strings.Join(want[:4], "\n")), 2)
+	if err != nil || d != nil {
+		t.Errorf("shorter reference: got %v, %v", d, err)
+	}
+}
+
+func TestReferenceLog(t *testing.T) {
+	code := []byte{
+		0xA2, 0x00, // LDX #$00
+		0xE8,             // INX
+		0x8E, 0x00, 0x03, // STX $0300
+		0x4C, 0x02, 0x80, // JMP $8002
+	}
+	console := newTestNes(t, code...)
+	var trace bytes.Buffer
+	console.SetTraceOutput(&trace)
+	for i := 0; i < 100; i++ {
+		console.Emulate()
+	}
+	lines := strings.Split(strings.TrimSuffix(trace.String(), "\n"), "\n")
+	if len(lines) != 100 {
+		t.Fatalf("traced %d lines, want 100", len(lines))
+	}
+
+	// the same program matches its own trace
+	console = newTestNes(t, code...)
+	console.SetReferenceLog(strings.NewReader(trace.String()))
+	for i := 0; i < 100; i++ {
+		console.Emulate()
+	}
+	if err := console.TraceDivergence(); err != nil {
+		t.Fatal(err)
+	}
+
+	// and fails to match one where X went wrong
+	x := strings.Index(lines[50], "X:") + 2
+	lines[50] = lines[50][:x] + "FF" + lines[50][x+2:]
+	console = newTestNes(t, code...)
+	console.SetReferenceLog(strings.NewReader(strings.Join(lines, "\n")))
+	for i := 0; i < 100; i++ {
+		console.Emulate()
+	}
+	var d *TraceDivergence
+	if !errors.As(console.TraceDivergence(), &d) {
+		t.Fatal("no divergence")
+	}
+	if d.Line != 51 || d.Field != "X" || len(d.Before) != traceContext || len(d.After) != traceContext {
+		t.Errorf("wrong divergence: %v", d)
+	}
+}